@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// Codes for errors from the admin API, besides the game codes.
+const (
+	errUnauthorized     = "UNAUTHORIZED"
+	errNotFound         = "NOT_FOUND"
+	errMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	errUnknownClient    = "UNKNOWN_CLIENT"
+)
+
+// adminAPI is the HTTP API operators use to look at and fix live games.
+// Every request needs the admin token as a bearer token.
+//
+//	GET    /admin/games                  every game
+//	GET    /admin/games/{id}             a game, with its current question and buzzes
+//	DELETE /admin/games/{id}             drop a game, players go back to the lobby
+//	POST   /admin/games/{id}/end         end a game, players are told it ended
+//	POST   /admin/clients/{id}/kick      close a client and give up its seat
+//	POST   /admin/announce               {"message", "gameId"} to a game, or the lobby
+type adminAPI struct {
+	hub   *Hub
+	token string
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || !equalSecret(strings.TrimPrefix(auth, "Bearer "), a.token) {
+		log.Println("Refused admin request from ", remoteIP(r))
+		writeAdminError(w, http.StatusUnauthorized, errUnauthorized, "Missing or invalid admin token")
+		return
+	}
+
+	// split before unescaping, ids may hold a /
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/admin"), "/"), "/")
+	for i, part := range parts {
+		if p, err := url.PathUnescape(part); err == nil {
+			parts[i] = p
+		}
+	}
+	log.Println("Admin request: ", r.Method, r.URL.Path)
+
+	switch {
+	case len(parts) == 1 && parts[0] == "games":
+		a.method(w, r, http.MethodGet, a.listGames)
+	case len(parts) == 2 && parts[0] == "games":
+		switch r.Method {
+		case http.MethodGet:
+			a.showGame(w, parts[1])
+		case http.MethodDelete:
+			a.deleteGame(w, parts[1])
+		default:
+			writeAdminError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "Use GET or DELETE")
+		}
+	case len(parts) == 3 && parts[0] == "games" && parts[2] == "end":
+		a.method(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			a.endGame(w, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "clients" && parts[2] == "kick":
+		a.method(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			a.kick(w, parts[1])
+		})
+	case len(parts) == 1 && parts[0] == "announce":
+		a.method(w, r, http.MethodPost, a.announce)
+	default:
+		writeAdminError(w, http.StatusNotFound, errNotFound, "No such admin endpoint")
+	}
+}
+
+// method runs the handler if the request uses the method.
+func (a *adminAPI) method(w http.ResponseWriter, r *http.Request, method string, handler http.HandlerFunc) {
+	if r.Method != method {
+		writeAdminError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "Use "+method)
+		return
+	}
+
+	handler(w, r)
+}
+
+func (a *adminAPI) listGames(w http.ResponseWriter, r *http.Request) {
+	gls, err := game.AllGames()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, errorCode(err), err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, gls)
+}
+
+func (a *adminAPI) showGame(w http.ResponseWriter, gameId string) {
+	g, ok := game.GetGame(gameId)
+	if !ok {
+		writeUnknownGame(w, gameId)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, protocol.GameDetail{Game: g, Question: g.QuestionState()})
+}
+
+// deleteGame drops the game without telling the players, they are sent
+// back to the lobby with the new game list.
+func (a *adminAPI) deleteGame(w http.ResponseWriter, gameId string) {
+	g, ok := game.GetGame(gameId)
+	if !ok {
+		writeUnknownGame(w, gameId)
+		return
+	}
+
+	if err := removeGame(a.hub, g.GameId); err != nil {
+		log.Println("Could not send GAMES: ", err)
+	}
+	writeAdminJSON(w, http.StatusOK, g)
+}
+
+// endGame ends the game as if it had been played out: the players and
+// spectators get GAME_ENDED with the final scores before it is dropped.
+func (a *adminAPI) endGame(w http.ResponseWriter, gameId string) {
+	if _, ok := game.GetGame(gameId); !ok {
+		writeUnknownGame(w, gameId)
+		return
+	}
+
+	g := game.SetGameState(gameId, game.ENDED)
+	err := MarshalAndSendToGame(a.hub, g, protocol.HeaderGameEnded, protocol.GameEnded{Game: g, Reason: "Ended by an admin"})
+	if err != nil {
+		log.Println("Could not send GAME_ENDED: ", err)
+	}
+
+	if err := removeGame(a.hub, g.GameId); err != nil {
+		log.Println("Could not send GAMES: ", err)
+	}
+	writeAdminJSON(w, http.StatusOK, g)
+}
+
+// kick closes every connection of the client and gives up its seat, if it
+// has one, without the usual grace window.
+func (a *adminAPI) kick(w http.ResponseWriter, clientId string) {
+	res := protocol.KickResult{ClientId: clientId, Connections: a.hub.Kick(clientId)}
+
+	if held, _ := heldSeats.claim(clientId); held != nil {
+		releaseSeat(held)
+		res.Seat = true
+	} else if _, ok := game.FindPlayerGame(clientId); ok {
+		releasePlayer(a.hub, clientId)
+		res.Seat = true
+	}
+
+	if res.Connections == 0 && !res.Seat {
+		writeAdminError(w, http.StatusNotFound, errUnknownClient, "Client "+clientId+" is not connected")
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, res)
+}
+
+// announce sends an ANNOUNCEMENT to the players and spectators of a game,
+// or to the lobby if no game is given.
+func (a *adminAPI) announce(w http.ResponseWriter, r *http.Request) {
+	// the body is sent on to the clients as it is
+	var req protocol.Announcement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		writeAdminError(w, http.StatusBadRequest, errBadBody, "Expected {\"message\": ..., \"gameId\": ...}")
+		return
+	}
+
+	var err error
+	if req.GameId == "" {
+		err = MarshalAndSendToRoom(a.hub, lobbyRoom, protocol.HeaderAnnouncement, req)
+	} else {
+		g, ok := game.GetGame(req.GameId)
+		if !ok {
+			writeUnknownGame(w, req.GameId)
+			return
+		}
+		err = MarshalAndSendToGame(a.hub, g, protocol.HeaderAnnouncement, req)
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, errorCode(err), err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, req)
+}
+
+func writeUnknownGame(w http.ResponseWriter, gameId string) {
+	writeAdminError(w, http.StatusNotFound, string(game.UnknownGame), "Unknown game "+gameId)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, code, msg string) {
+	writeAdminJSON(w, status, protocol.Error{Code: code, Message: msg})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Could not write admin response: ", err)
+	}
+}
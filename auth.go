@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gogo-sockets/protocol"
+)
+
+var errBadCredentials = errors.New("Invalid credentials")
+
+// Authenticator checks the credentials in a HELO.
+type Authenticator interface {
+	// Authenticate returns the clientId the peer is trusted to use, or an
+	// error if it is not let in.
+	Authenticate(h *protocol.Hello) (string, error)
+}
+
+// newAuthenticator builds the Authenticator for the -auth mode.
+func newAuthenticator(cfg *Config) (Authenticator, error) {
+	switch cfg.AuthMode {
+	case "static":
+		if cfg.AuthKey == "" {
+			return nil, errors.New("static auth needs a key, set -auth-key or GOGO_AUTH_KEY")
+		}
+		return &staticKeyAuth{key: cfg.AuthKey}, nil
+	case "token":
+		if cfg.AuthSecret == "" {
+			return nil, errors.New("token auth needs a secret, set -auth-secret or GOGO_AUTH_SECRET")
+		}
+		return &tokenAuth{secret: []byte(cfg.AuthSecret), now: time.Now}, nil
+	case "file":
+		a := &fileKeyAuth{path: cfg.AuthKeysFile}
+		if err := a.load(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+
+	return nil, fmt.Errorf("Unknown auth mode %q", cfg.AuthMode)
+}
+
+// staticKeyAuth lets in anyone holding the one shared key. The clientId
+// is taken on trust.
+type staticKeyAuth struct {
+	key string
+}
+
+func (a *staticKeyAuth) Authenticate(h *protocol.Hello) (string, error) {
+	if h.ClientId == "" || !equalSecret(h.Key, a.key) {
+		return "", errBadCredentials
+	}
+
+	return h.ClientId, nil
+}
+
+// tokenAuth lets in peers holding an unexpired token signed with the
+// secret. The token binds the clientId, see signToken.
+type tokenAuth struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// signToken issues a token for the clientId, valid until expires. Tokens
+// look like clientId.expiry.signature, the expiry in unix seconds.
+func signToken(secret []byte, clientId string, expires time.Time) string {
+	payload := clientId + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + tokenSignature(secret, payload)
+}
+
+func tokenSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *tokenAuth) Authenticate(h *protocol.Hello) (string, error) {
+	sig := strings.LastIndexByte(h.Token, '.')
+	if sig < 0 {
+		return "", errBadCredentials
+	}
+	payload := h.Token[:sig]
+	if !hmac.Equal([]byte(h.Token[sig+1:]), []byte(tokenSignature(a.secret, payload))) {
+		return "", errBadCredentials
+	}
+
+	exp := strings.LastIndexByte(payload, '.')
+	if exp < 0 {
+		return "", errBadCredentials
+	}
+	clientId := payload[:exp]
+	expires, err := strconv.ParseInt(payload[exp+1:], 10, 64)
+	if err != nil || a.now().Unix() > expires {
+		return "", errors.New("Token expired")
+	}
+
+	// the token decides who the peer is
+	if clientId == "" || (h.ClientId != "" && h.ClientId != clientId) {
+		return "", errors.New("Token does not match clientId")
+	}
+
+	return clientId, nil
+}
+
+// fileKeyAuth lets in peers holding the key of their tenant. The keys are
+// read from a file of "tenant key" lines, blank lines and lines starting
+// with # are skipped. The file is read again when it changes, so keys can
+// be rotated without a restart.
+type fileKeyAuth struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    map[string]string
+}
+
+// load reads the key file if it changed since it was last read.
+func (a *fileKeyAuth) load() error {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.keys != nil && fi.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected \"tenant key\"", a.path, n)
+		}
+		keys[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.keys, a.modTime = keys, fi.ModTime()
+	return nil
+}
+
+func (a *fileKeyAuth) Authenticate(h *protocol.Hello) (string, error) {
+	// keep the keys we have if the file is briefly unreadable mid-rotation
+	if err := a.load(); err != nil {
+		log.Println("Could not reload key file: ", err)
+	}
+
+	a.mu.Lock()
+	key, ok := a.keys[h.Tenant]
+	a.mu.Unlock()
+
+	if !ok || h.ClientId == "" || !equalSecret(h.Key, key) {
+		return "", errBadCredentials
+	}
+
+	return h.ClientId, nil
+}
+
+// equalSecret compares secrets in constant time.
+func equalSecret(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gogo-sockets/protocol"
+)
+
+func TestTokenAuth(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	a := &tokenAuth{secret: secret, now: func() time.Time { return now }}
+
+	valid := signToken(secret, "alice", now.Add(time.Hour))
+	tampered := valid[:len(valid)-1] + "x"
+	if strings.HasSuffix(valid, "x") {
+		tampered = valid[:len(valid)-1] + "y"
+	}
+
+	tests := []struct {
+		name  string
+		hello protocol.Hello
+		want  string
+		err   bool
+	}{
+		{name: "valid", hello: protocol.Hello{Token: valid}, want: "alice"},
+		{name: "valid with its clientId", hello: protocol.Hello{Token: valid, ClientId: "alice"}, want: "alice"},
+		{name: "expired", hello: protocol.Hello{Token: signToken(secret, "alice", now.Add(-time.Second))}, err: true},
+		{name: "tampered signature", hello: protocol.Hello{Token: tampered}, err: true},
+		{name: "other secret", hello: protocol.Hello{Token: signToken([]byte("other"), "alice", now.Add(time.Hour))}, err: true},
+		{name: "clientId mismatch", hello: protocol.Hello{Token: valid, ClientId: "mallory"}, err: true},
+		{name: "no token", hello: protocol.Hello{ClientId: "alice"}, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := a.Authenticate(&tt.hello)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: let in as %q", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestFileKeyAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// a rewrite within the same tick of the clock keeps the mod time
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	write("# tenants\nacme old-key\n\nglobex globex-key\n", start)
+	cfg := defaultConfig()
+	cfg.AuthMode, cfg.AuthKeysFile = "file", path
+	a, err := newAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(tenant, key string, ok bool) {
+		t.Helper()
+		got, err := a.Authenticate(&protocol.Hello{Tenant: tenant, Key: key, ClientId: "c1"})
+		if ok && (err != nil || got != "c1") {
+			t.Errorf("%s/%s: got %q, %v, want c1", tenant, key, got, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s/%s: let in", tenant, key)
+		}
+	}
+
+	check("acme", "old-key", true)
+	check("globex", "globex-key", true)
+	check("initech", "old-key", false)
+	check("acme", "globex-key", false)
+
+	// rotated: the old key is out, the new one in
+	write("acme new-key\n", start.Add(time.Second))
+	check("acme", "old-key", false)
+	check("acme", "new-key", true)
+	check("globex", "globex-key", false)
+
+	// an unreadable file mid-rotation keeps the keys we have
+	write("acme\n", start.Add(2*time.Second))
+	check("acme", "new-key", true)
+}
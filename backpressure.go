@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// messageClass groups outbound messages by how much losing one hurts.
+type messageClass int
+
+const (
+	// The GAMES list sent to the lobby, only the latest one matters.
+	classLobby messageClass = iota
+
+	// Everything else: game events, replies and errors.
+	classGameplay
+)
+
+func (c messageClass) String() string {
+	if c == classLobby {
+		return "lobby"
+	}
+	return "gameplay"
+}
+
+// classOf is the class of a message with the header.
+func classOf(header string) messageClass {
+	if header == "GAMES" {
+		return classLobby
+	}
+	return classGameplay
+}
+
+// slowPolicy says what happens to a message for a client whose Send
+// buffer is full.
+type slowPolicy int
+
+const (
+	// Hold the message back, replacing any held message with the same
+	// header. Like slowWait, a client that never catches up is
+	// disconnected.
+	slowCoalesce slowPolicy = iota
+
+	// Hold the message back. A client still behind when the
+	// -slow-consumer-timeout runs out is disconnected, and may resume.
+	slowWait
+
+	// Disconnect the client right away, it may resume.
+	slowEvict
+)
+
+func parseSlowPolicy(s string) (slowPolicy, error) {
+	switch s {
+	case "coalesce":
+		return slowCoalesce, nil
+	case "wait":
+		return slowWait, nil
+	case "evict":
+		return slowEvict, nil
+	}
+
+	return slowWait, fmt.Errorf("Unknown backpressure policy %q", s)
+}
+
+// hold keeps back a message the Send buffer has no room for, applying
+// the policy of its class. A client with -max-overflow messages held
+// already is disconnected whatever the policy. Called with mu held.
+func (c *Client) hold(msg []byte) {
+	cfg := c.Hub.cfg
+	class := classOf(frameHeader(msg))
+
+	switch cfg.backpressure[class] {
+	case slowEvict:
+		// recorded for replay like the rest, the resumed client must
+		// not miss it
+		c.overflow = append(c.overflow, msg)
+		c.evict(class)
+		return
+	case slowCoalesce:
+		header := frameHeader(msg)
+		for i, held := range c.overflow {
+			if frameHeader(held) == header {
+				c.overflow[i] = msg
+				sendDrops.inc("")
+				return
+			}
+		}
+	}
+
+	if len(c.overflow) >= cfg.MaxOverflow {
+		c.overflow = append(c.overflow, msg)
+		c.evict(class)
+		return
+	}
+
+	c.overflow = append(c.overflow, msg)
+	if c.slowTimer == nil {
+		var t *time.Timer
+		t = time.AfterFunc(cfg.SlowConsumerTimeout, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			// a timer stopped too late must not evict a client that caught up
+			if c.slowTimer == t && !c.closed {
+				c.evict(class)
+			}
+		})
+		c.slowTimer = t
+	}
+}
+
+// refill moves held back messages into the Send buffer as it drains.
+// Called by the writePump.
+func (c *Client) refill() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	n := 0
+	for ; n < len(c.overflow) && len(c.Send) < cap(c.Send); n++ {
+		c.Send <- c.out.stamp(c.overflow[n])
+	}
+	c.overflow = c.overflow[n:]
+
+	if len(c.overflow) == 0 {
+		c.stopSlowTimer()
+	}
+}
+
+// evict disconnects a client that cannot keep up. Held back messages are
+// recorded for replay and the client is detached, so a seated player
+// keeps their seat and can resume. Called with mu held.
+func (c *Client) evict(class messageClass) {
+	log.Printf("Evicting slow client %s: %d %s messages held back", c.ClientId, len(c.overflow), class)
+	slowEvictions.inc(class.String())
+
+	c.flushOverflow()
+	c.detached = true
+	if !c.closed {
+		c.closeCode, c.closeText = closeSlow, "Too slow, reconnect to resume"
+		c.closed = true
+		close(c.Send)
+	}
+}
+
+// flushOverflow records the held back messages for replay, they will
+// not be sent on this connection. Called with mu held.
+func (c *Client) flushOverflow() {
+	for _, msg := range c.overflow {
+		c.out.stamp(msg)
+	}
+	c.overflow = nil
+	c.stopSlowTimer()
+}
+
+func (c *Client) stopSlowTimer() {
+	if c.slowTimer != nil {
+		c.slowTimer.Stop()
+		c.slowTimer = nil
+	}
+}
+
+// parseBackpressure reads policies like "lobby=coalesce,gameplay=wait".
+// Classes that are not mentioned keep their default: coalesce for the
+// lobby, wait for gameplay.
+func parseBackpressure(s string) (map[messageClass]slowPolicy, error) {
+	policies := map[messageClass]slowPolicy{
+		classLobby:    slowCoalesce,
+		classGameplay: slowWait,
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Expected class=policy, got %q", part)
+		}
+
+		policy, err := parseSlowPolicy(kv[1])
+		if err != nil {
+			return nil, err
+		}
+
+		switch kv[0] {
+		case "lobby":
+			policies[classLobby] = policy
+		case "gameplay":
+			policies[classGameplay] = policy
+		default:
+			return nil, fmt.Errorf("Unknown message class %q", kv[0])
+		}
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseBackpressure(t *testing.T) {
+	tests := []struct {
+		in    string
+		lobby slowPolicy
+		game  slowPolicy
+		err   bool
+	}{
+		{in: "", lobby: slowCoalesce, game: slowWait},
+		{in: "lobby=evict", lobby: slowEvict, game: slowWait},
+		{in: "gameplay=coalesce", lobby: slowCoalesce, game: slowCoalesce},
+		{in: " lobby=wait , gameplay=evict ", lobby: slowWait, game: slowEvict},
+		{in: "lobby", err: true},
+		{in: "lobby=drop", err: true},
+		{in: "chat=wait", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBackpressure(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseBackpressure(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBackpressure(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got[classLobby] != tt.lobby || got[classGameplay] != tt.game {
+			t.Errorf("parseBackpressure(%q) = %v, want lobby %v gameplay %v", tt.in, got, tt.lobby, tt.game)
+		}
+	}
+}
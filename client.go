@@ -6,28 +6,34 @@ import (
 	"bytes"
 	"log"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
   "encoding/json"
 
   "gogo-sockets/game"
+  "gogo-sockets/protocol"
 
 	"github.com/gorilla/websocket"
 )
 
+// The timeouts and sizes of sockets are in the Config of the hub.
 const (
-	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
+	// Close codes for connections ended because of the duplicate client
+	// policy, from the range left to applications.
+	closeReplaced  = 4001
+	closeDuplicate = 4002
 
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 2 * time.Second
+	// Close code for connections an admin kicked.
+	closeKicked = 4003
 
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
+	// Close code for clients that could not keep up with their messages.
+	closeSlow = 4004
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
-
-  secretKey = "293faecad3499bdd836090ffc2a72693954be4c842c5728ae9a2148cf802a9359fe27a6ad42af696d3d3008557c8953f93c3681764edad05aa237932e1cc9d45678e7386f625c8d119595e67ff404312ddcfa642f4a2816fc838dc2ec3924fa044c92a7e0cb2e493519ec18a6d4879a9e091312c58f2bc472aa52dcca955799b"
+	// Every frame starts with a space padded header, the message type
+	// followed by the sequence id of outbound frames, right aligned.
+	headerLen     = protocol.HeaderLen
+	headerTypeLen = protocol.HeaderTypeLen
 )
 
 var (
@@ -35,673 +41,552 @@ var (
 	space   = []byte{' '}
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	//CheckOrigin: func(r *http.Request) bool {
-    //    return true
-    //},
-}
+// Set once the server is shutting down, no new sockets are accepted.
+var draining int32
+
+// Set up in main from the settings and the origin allowlist.
+var upgrader = websocket.Upgrader{}
 
-// Client is a middleman between the websocket connection and the hub.
+// Client is a middleman between its session, the connection to the
+// peer, and the hub.
 type Client struct {
 	Hub *Hub
 
   // The client identifier
   ClientId string
 
-	// The websocket connection.
-	Conn *websocket.Conn
+	// The protocol version agreed on in the handshake, the capabilities
+	// both sides support and the framing of the messages, see negotiate.
+	Version int
+	caps    map[string]bool
+	framing protocol.Framing
+
+	// The connection, a websocket or one of the HTTP fallbacks.
+	Session Session
 
 	// Buffered channel of outbound messages.
 	Send chan []byte
+
+	// Numbers the outbound messages and keeps them for replay.
+	out *outbox
+
+	// Replies to recent requests, and to the one being handled. pending
+	// is only touched by the goroutine handling the client's messages.
+	replies *replyCache
+	pending *pendingRequest
+
+	// Guards sends on Send against it being closed.
+	mu     sync.Mutex
+	closed bool
+
+	// Messages held back while Send is full, not yet stamped, and the
+	// timer that evicts the client if it does not catch up.
+	overflow  [][]byte
+	slowTimer *time.Timer
+
+	// A detached client lost its connection but keeps its rooms, messages
+	// for it are only recorded in out.
+	detached bool
+
+	// The detached client of the dropped connection this one resumes, and
+	// the sequence id of the last frame the peer got on it.
+	previous *Client
+	lastSeq  uint64
+
+	// Set by the hub once the frames missed since lastSeq are queued.
+	caughtUp bool
+
+	// Why the session is closed once Send is closed, if the hub gave a
+	// reason.
+	closeCode int
+	closeText string
+
+	// Where the connection comes from, for the connection limits.
+	remoteIP string
+
+	// Rate limits over all messages and by header, and the refused
+	// messages that get the client disconnected, see checkRate. Set
+	// once it is being disconnected, its messages are ignored.
+	total   tokenBucket
+	buckets map[string]*tokenBucket
+	strikes tokenBucket
+	abusive bool
 }
 
-// readPump pumps messages from the websocket connection to HandleMessage.
+// readPump pumps messages from the session to HandleMessage.
 //
 // The application runs readPump in a per-connection goroutine. The application
 // ensures that there is at most one reader on a connection by executing all
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
-		g, remove := game.RemovePlayer(c.ClientId)
-		c.Hub.unregister <- c
-		c.Conn.Close()
-		
-		if g != nil {
-			if remove {
-				// TODO: send the remaining players a game abandoned message
-				// ...
-			
-				game.RemoveGame(g.GameId)
-				gls, err := game.AllGames()
-				if err != nil {
-					// TODO: not sure what happens if you try to send an error back to a disconnected client
-					//SendError(c, err)
-					return
-				}
-				
-				// since its broadcast it shouldn't matter that the client is not active
-				err = MarshalAndSend(c, "GAMES", gls, true)
-				if err != nil {
-					//SendError(c, err)
-					return
-				}
-			
-			} else {
-				// not sure yet what to do yet if there are still players left in the game
-				//game.RemoveGame(g.GameId)
-			}
+		c.Session.Close()
+		c.Hub.guard.release(c.remoteIP)
+
+		// seated players keep their rooms while they are away, unless they
+		// are still connected on another socket
+		_, seated := game.FindPlayerGame(c.ClientId)
+		if !c.Hub.Drop(c, seated) {
+			return
 		}
+
+		// hold on to their seat, they may just be switching networks
+		dropSeat(c)
 	}()
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.Conn.SetPongHandler(func(string) error { c.Conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		message, _, err := c.Session.Read()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
-			}
 			break
 		}
-		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-    HandleMessage(c, message)
+		if c.abusive {
+			// the session ends once the writePump says why
+			continue
+		}
+		if !c.framing.Binary() {
+			message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
+		}
+		frames, err := c.framing.Decode(message)
+		if err != nil {
+			if c.checkRate("", "") {
+				SendError(c, "", err)
+			}
+			continue
+		}
+		for _, f := range frames {
+			id := f.Id
+			if id == "" {
+				id = requestIdOf(f.Body)
+			}
+			if c.checkRate(f.Header, id) {
+				messages.Dispatch(c, f)
+			}
+		}
 	}
 }
 
-// writePump pumps messages from the hub to the websocket connection.
+// writePump pumps messages from the hub to the session.
 //
 // A goroutine running writePump is started for each connection. The
 // application ensures that there is at most one writer to a connection by
 // executing all writes from this goroutine.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.Hub.cfg.pingPeriod())
 	defer func() {
 		ticker.Stop()
-		c.Conn.Close()
+		c.Session.Close()
+		c.Hub.pumps.Done()
 	}()
 	for {
 		select {
 		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+				c.Session.CloseWith(c.closeStatus())
 				return
 			}
-			w.Write(message)
 
 			// Add queued chat messages to the current websocket message.
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.Send)
+			batch := [][]byte{message}
+			if c.Can(protocol.CapBatch) {
+				for n := len(c.Send); n > 0; n-- {
+					batch = append(batch, <-c.Send)
+				}
 			}
 
-			if err := w.Close(); err != nil {
+			data, err := c.encode(batch)
+			if err != nil {
+				log.Println("Could not encode frames: ", err)
+				return
+			}
+			if err := c.Session.Write(data, c.framing.Binary()); err != nil {
 				return
 			}
+			for _, frame := range batch {
+				messagesOut.inc(frameHeader(frame))
+			}
+			c.refill()
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.Session.Ping(); err != nil {
 				return
 			}
 		}
 	}
 }
 
-// serveWs handles websocket requests from the peer.
-func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println(err)
-		return
+// encode puts the frames in one message, in the framing the client asked
+// for.
+func (c *Client) encode(frames [][]byte) ([]byte, error) {
+	fs := make([]protocol.Frame, len(frames))
+	for i, frame := range frames {
+		f, err := protocol.Decode(frame)
+		if err != nil {
+			return nil, err
+		}
+		f.Id = protocol.RequestIdOf(f.Body)
+		if !c.Can(protocol.CapSeq) && c.framing.Name() == protocol.FramingLegacy {
+			// their header is the type and blanks, as it always was
+			f.Seq = 0
+		}
+		fs[i] = f
 	}
 
-  go authAndRegister(hub, conn)
+	return c.framing.Encode(fs)
 }
 
-func authAndRegister(hub *Hub, conn *websocket.Conn) {
-  // get the initial message -- validate it meets our expectations
-  // and happens within a few seconds...
-
-  // initial conn settings to be overridden in the client
-  conn.SetReadLimit(1024)
-  conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+// queue stamps the message with the client's next sequence id and hands
+// it to the writePump. If the client's buffer is full the message is held
+// back, see hold. Returns false if the client has been closed, the
+// message is not sent.
+func (c *Client) queue(msg []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.detached {
+		c.out.stamp(msg)
+		return true
+	}
 
-  msgFormat, msg, err := conn.ReadMessage()
-  if err != nil {
-    log.Println("Could not read initial message from client: ", err)
-    conn.Close()
-    return
-  }
+	// all sends happen under mu, so a free slot stays free
+	if c.closed {
+		return false
+	}
 
-  // validate the initial message
-  if len(msg) < 32 { // err
-    log.Println("Invalid initial message from client (len < 32) ")
-    conn.Close()
-    return
-  }
+	// held back messages go first
+	if len(c.overflow) > 0 || len(c.Send) == cap(c.Send) {
+		c.hold(msg)
+		return true
+	}
 
-  if msgFormat != websocket.TextMessage {
-    log.Println("Invalid initial message format (expected text got binary)")
-    conn.Close()
-    return
-  }
+	c.Send <- c.out.stamp(msg)
+	return true
+}
 
-  msgType := string(bytes.TrimSpace(msg[:32]))
+// requeue hands already stamped frames to the writePump again.
+func (c *Client) requeue(frames [][]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-  if msgType != "HELO" {
-    log.Println("Invalid initial message type, expected HELO got ", msgType)
-    conn.Close()
-    return
-  }
+	if c.closed || cap(c.Send)-len(c.Send) < len(frames) {
+		return false
+	}
 
-  initMsg := struct {
-    Key string `json:"key"`
-    ClientId string `json:"clientId"`
-  }{}
+	for _, frame := range frames {
+		c.Send <- frame
+	}
+	return true
+}
 
-  err = json.Unmarshal(msg[32:], &initMsg)
-  if err != nil {
-    log.Println("Could not unmarshal initial message: ", err)
-    conn.Close()
-    return
-  }
+// catchUp queues the frames stamped after lastSeq. Called from the hub
+// before the client takes over the rooms of the one it resumes.
+func (c *Client) catchUp() {
+	if c.lastSeq == 0 || !c.Can(protocol.CapResume) {
+		return
+	}
 
-  if initMsg.Key != secretKey {
-    log.Println("Invalid key")
-    conn.Close()
-    return
-  }
+	frames, ok := c.out.since(c.lastSeq)
+	c.caughtUp = ok && c.requeue(frames)
+}
 
-  // in memory client -- identifed by memory address
-  client := &Client{ClientId: initMsg.ClientId, Hub: hub, Conn: conn, Send: make(chan []byte, 256)}
+// detach closes the Send channel but keeps recording the messages for the
+// client.
+func (c *Client) detach() {
+	c.mu.Lock()
+	c.flushOverflow()
+	c.detached = true
+	c.mu.Unlock()
 
-	client.Hub.register <- client
+	c.closeSend()
+}
 
-  // welcome to the app
-  velcomen, _ := MakeMessage("INIT", nil)
-  HandleMessage(client, velcomen)
+// closeWith closes the Send channel, the writePump tells the peer why.
+func (c *Client) closeWith(code int, reason string) {
+	c.mu.Lock()
+	if !c.closed {
+		c.closeCode, c.closeText = code, reason
+	}
+	c.mu.Unlock()
 
-	// Allow collection of memory referenced by the caller
-  // by doing all work in new goroutines.
-	go client.writePump()
-	go client.readPump()
+	c.closeSend()
 }
 
+// closeStatus is the close code and reason for the peer, the code is 0
+// if the hub gave none.
+func (c *Client) closeStatus() (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-// Handles the message, including sending an error if required
-func HandleMessage(client *Client, msg []byte) {
-  if len(msg) < 32 {
-    SendError(client, errors.New("Invalid message length, must be > 31"))
-    return
-  }
+	return c.closeCode, c.closeText
+}
 
-  header := string(bytes.TrimSpace(msg[:32]))
-  fmt.Println("Processing message: ", header)
-  fmt.Println("Message body: ", string(msg[32:]))
-
-  switch header {
-  case "INIT":
-    // send the games
-    gls, err := game.AllGames()
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+// closeSend closes the Send channel, once.
+func (c *Client) closeSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-    err = MarshalAndSend(client, "GAMES", gls, false)
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+	if !c.closed {
+		c.closed = true
+		close(c.Send)
+	}
+	c.overflow = nil
+	c.stopSlowTimer()
+}
 
-  case "GAME_REQ":
-    req := struct { Action string
-					GameId string
-					Name string
-					NumCategories uint8
-					QuestionsPerCategory uint8 
-          TotalQuestions uint8 }{}
-    err := json.Unmarshal(msg[32:], &req)
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+// serveWs handles websocket requests from the peer.
+func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&draining) == 1 {
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+		return
+	}
 
-    switch req.Action {
-    case "CREATE":
-      // this player will be the host
-      g := game.CreateGame(client.ClientId, req.Name, req.NumCategories, req.QuestionsPerCategory, req.TotalQuestions)
-      err := MarshalAndSend(client, "START_WAIT", g, false)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      // broadcast the new game list
-      gls, err := game.AllGames()
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      err = MarshalAndSend(client, "GAMES", gls, true)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      return
-    case "JOIN":
-      // this player will be the host
-      g, err := game.JoinGame(req.GameId, client.ClientId, req.Name)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      if (len(g.Players) == 3) {
-	    err = MarshalAndSendToGame(client, g, "START_ROUND", g)
-		if err != nil {
-          SendError(client, err)
-          return
-        }
-	  } else {
-	    err = MarshalAndSendToGame(client, g, "START_WAIT", g)
-        if err != nil {
-          SendError(client, err)
-          return
-        }
-	  }
-
-      // broadcast the new game list
-      gls, err := game.AllGames()
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      err = MarshalAndSend(client, "GAMES", gls, true)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-    case "LEAVE":
-      g, err := game.LeaveGame(req.GameId, client.ClientId)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-      if g != nil { // there are others waiting
-        err = MarshalAndSendToGame(client, g, "START_WAIT", g)
-        if err != nil {
-          SendError(client, err)
-          return
-        }
-      }
-
-      // broadcast the new game list
-      gls, err := game.AllGames()
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      err = MarshalAndSend(client, "GAMES", gls, true)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-    }
+	// limits and bans are checked before upgrading
+	ip, ok := admitConn(hub, w, r)
+	if !ok {
+		return
+	}
 
-  case "BEGIN_GAME":
-    // we should have a question count
-    body := struct { 
-      GameId string 
-      QuestionCount uint8 
-    }{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		hub.guard.release(ip)
+		return
+	}
 
-    err := json.Unmarshal(msg[32:], &body)
-    if err != nil {
-      SendError(client, err)
+  go func() {
+    if !authAndRegister(hub, newWsSession(conn, hub.cfg), ip) {
+      hub.guard.release(ip)
     }
+  }()
+}
 
-    // update the game with the question count and start round
-    g, err := game.UpdateQuestionCount(body.GameId, body.QuestionCount)
-    if err != nil {
-      SendError(client, err)
-    }
+// admitConn counts a new connection from the peer with the hub's guard, or
+// refuses it. Admitted connections must be released.
+func admitConn(hub *Hub, w http.ResponseWriter, r *http.Request) (string, bool) {
+	ip := remoteIP(r)
+	if err := hub.guard.admit(ip); err != nil {
+		log.Println("Refused connection from ", ip, ": ", err)
+		status := http.StatusTooManyRequests
+		if err == errBanned {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return ip, false
+	}
 
-    err = MarshalAndSendToGame(client, g, "START_ROUND", g)
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+	return ip, true
+}
 
-    // broadcast the new game list
-    gls, err := game.AllGames()
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+// Returns false if the session was closed without registering a client.
+func authAndRegister(hub *Hub, session Session, ip string) bool {
+  // get the initial message -- validate it meets our expectations,
+  // the session makes sure it happens within a few seconds...
+  msg, binary, err := session.Read()
+  if err != nil {
+    log.Println("Could not read initial message from client: ", err)
+    session.Close()
+    return false
+  }
 
-    err = MarshalAndSend(client, "GAMES", gls, true)
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+  // validate the initial message, it may come in any framing
+  framing := protocol.DetectFraming(msg, binary)
+  frames, err := framing.Decode(msg)
+  if err != nil || len(frames) != 1 {
+    log.Println("Invalid initial message from client: ", err)
+    session.Close()
+    return false
+  }
 
-  case "NEXT_ROUND":
-    // we should have a game id
-    body := struct {
-      GameId string `json:"gameId"`
-    }{}
+  if frames[0].Header != protocol.HeaderHello {
+    log.Println("Invalid initial message type, expected HELO got ", frames[0].Header)
+    session.Close()
+    return false
+  }
 
-    err := json.Unmarshal(msg[32:], &body)
-    if err != nil {
-      SendError(client, err)
-      return
-    }
+  initMsg := protocol.Hello{}
 
+  err = protocol.DecodeBody(frames[0].Body, &initMsg)
+  if err != nil {
+    log.Println("Could not unmarshal initial message: ", err)
+    session.Close()
+    return false
+  }
 
-    g  := game.SetGameState(body.GameId, game.SPIN)
+  clientId, err := hub.auth.Authenticate(&initMsg)
+  if err != nil {
+    log.Println("Authentication failed: ", err)
+    authFailures.inc("")
+    hub.guard.fail(ip)
+    session.Close()
+    return false
+  }
 
-    err = MarshalAndSendToGame(client, g, "START_ROUND", g)
-    if err != nil {
-      SendError(client, err)
-      return
+  // in memory client -- identifed by memory address
+  client := &Client{ClientId: clientId, Hub: hub, Session: session, Send: make(chan []byte, hub.cfg.SendBuffer), out: newOutbox(hub.cfg.ReplaySize), replies: newReplyCache(hub.cfg.DedupeWindow, hub.cfg.DedupeMax), lastSeq: initMsg.LastSeq, remoteIP: ip}
+  negotiate(client, &initMsg, framing)
+
+  // a held seat comes with the frames sent while the client was away,
+  // clients that cannot resume give it up
+  previous, deadline := heldSeats.claim(client.ClientId)
+  if previous != nil {
+    if client.Can(protocol.CapResume) {
+      client.previous = previous
+      client.out = previous.out
+      client.replies = previous.replies
+    } else {
+      releaseSeat(previous)
     }
+  }
 
-    // broadcast the new game list
-    gls, err := game.AllGames()
-    if err != nil {
-      SendError(client, err)
-      return
+  if err := client.Hub.Register(client); err != nil {
+    if err == errShuttingDown {
+      // registered too late for the SERVER_SHUTDOWN the others got
+      refuseShutdown(client)
+    } else {
+      log.Println("Refused second connection for client ", client.ClientId)
+      session.CloseWith(closeDuplicate, "Already connected")
     }
 
-    err = MarshalAndSend(client, "GAMES", gls, true)
-    if err != nil {
-      SendError(client, err)
-      return
+    // the seat we claimed is still held, until it would have been anyway
+    if client.previous != nil {
+      holdSeat(client.previous, deadline)
     }
+    return false
+  }
 
-  case "GAMEPLAY":
-    // unmarshal the message to get the gameplay req type
-    reqPart := struct { Request string `json:"request"`
-    GameId string `json:"gameId"`}{}
-	
-	err := json.Unmarshal(msg[32:], &reqPart)
-	if err != nil {
-		SendError(client, err)
-	}
+  // nothing is written to the session until the writePump starts, so
+  // WELCOME goes out first
+  if err := welcome(client); err != nil {
+    log.Println("Could not send WELCOME: ", err)
+  }
 
-  g, ok := game.GetGame(reqPart.GameId)
-  if !ok {
-    SendError(client, fmt.Errorf("Unknown gameId: %v", reqPart.GameId))
+  // back from a dropped connection? pick the game back up
+  // otherwise welcome to the app
+  if !resumeSeat(client) {
+    velcomen, _ := MakeMessage("INIT", nil)
+    HandleMessage(client, velcomen)
   }
 
-	switch reqPart.Request {
-	  case "WHEEL_SPIN":
-		reqFull := struct { Request string `json:"request"`
-							SpinFactor int `json:"spinFactor"`}{}
-		err := json.Unmarshal(msg[32:], &reqFull)
-		if err != nil {
-			SendError(client, err)
-		}
-			
-		// create a wheel spun message and send it to the other clients
-		// doesn't need any handling from game package
-		spinFwd := struct { 
-							PlayerId string `json:"playerId"`
-							SpinFactor int `json:"spinFactor"`}{}	
-		spinFwd.PlayerId = client.ClientId
-		spinFwd.SpinFactor = reqFull.SpinFactor
-		
-		err = MarshalAndSendToGame(client, g, "WHEEL_SPUN", spinFwd)
-        if err != nil {
-          SendError(client, err)
-          return
-        }
-		
-	  case "QUESTION_SELECT":
-		reqFull := struct { Request string `json:"request"`
-							GameId string `json:"gameId"`
-							Category string 	`json:"category"`
-							PointValue uint8	`json:"pointValue"`}{}
-		err := json.Unmarshal(msg[32:], &reqFull)
-		if err != nil {
-			SendError(client, err)
-		}
-		
-		// get the question and send it back to everyone
-		q, err := game.QuestionSelect(reqFull.GameId,
-									  reqFull.Category,
-									  reqFull.PointValue)
-		if err != nil {
-			SendError(client, err)
-		}
+	// Allow collection of memory referenced by the caller
+  // by doing all work in new goroutines. The hub counted the writePump.
+	go client.writePump()
+	go client.readPump()
+  return true
+}
 
-    g := game.SetGameState(reqFull.GameId, game.QUESTION) 
-		
-		// send question to everyone
-		err = MarshalAndSend(client, "QUESTION_RESPONSE", struct{ 
-      Question *game.Question `json:"question"`
-      Game *game.Game `json:"game"`
-    }{&q, g}, true)
-
-    if err != nil {
-      SendError(client, err)
-      return
-    }
-		
-	  case "BUZZ":
-		// register the buzz, if this is the third buzz then choose
-		// the current player and send the question to everyone
-    // send this buzz to the game
-    // we exepect the clients to all buzz -- we will wait forever for
-    // the third buzz. If the client expires then the delay will be
-    // 1 << 16 -- if all buzz are 1 << 16 the question is over.
-		reqFull := struct { Request string `json:"request"`
-							GameId string `json:"gameId"`
-							Delay uint32		`json:"delay"`}{}
-		err := json.Unmarshal(msg[32:], &reqFull)
-    if err != nil { // TODO: this can lead to a hung game, add handling in client
-			SendError(client, err)
-		}
-		
-    // do we have 3 buzzes? 
-    choosePlayer := game.RegisterBuzz(reqFull.GameId, client.ClientId, reqFull.Delay, reqFull.Delay == 1 << 16)
-
-
-    // no?
-    if !choosePlayer {
-      // is this buzz a timeout?
-      if reqFull.Delay != 1 << 16  { // no
-        // send this buzz to the other clients
-        err = MarshalAndSendToGame(client, g, "BUZZED", struct{ 
-          PlayerId string `json:"playerId"`
-          Delay uint32 `json:"delay"`}{ client.ClientId, reqFull.Delay })
-        // return
-        return
-      }
-      // yes, it is a timeout?
-      // return and wait for more buzzes
-      return
-    }
 
-    // yes, there are 3 buzzes?
-    // what is the best buzz?
-    // select the player based on the best buzz or timeout
-    // handled in SetNewCurrentPlayer
-    expired, g, err := game.SetNewCurrentPlayer(g)
-    // did all buzzes happen as a timeout?
-    if expired { // yes?
-      // cancel question, send answer
-      // call IncomingAnswer with no cliendId
-      correct, correctAnswer, ga, err := game.IncomingAnswer(reqFull.GameId,
-      "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx",
-      0)
-      if err != nil {
-        SendError(client, err)
-      }
-
-      // send answer response message
-      answerResp := struct { Correct bool `json:"correct"`
-      CorrectAnswer int `json:"correctAnswer"`
-      Game *game.Game `json:"game"`}{}				
-      answerResp.Correct = correct
-      answerResp.CorrectAnswer = correctAnswer
-      answerResp.Game = ga
-
-      err = MarshalAndSendToGame(client, g, "ANSWER_RESPONSE", answerResp)
-      if err != nil {
-        SendError(client, err)
-        return
-      }
-
-      if g.State == game.ENDED {
-        game.RemoveGame(g.GameId)
-        // gls, err := game.AllGames()
-        // if err != nil {
-        //   SendError(client, err)
-        //   return
-        // }
-        // err = MarshalAndSend(client, "GAMES", gls, true);
-        // if err != nil {
-        //   SendError(client, err)
-        //   return
-        // }
-
-        return
-      }
-
-      return
+// refuseShutdown sends a client the hub refused on shutdown the same last
+// message as the others, and closes its session.
+func refuseShutdown(client *Client) {
+  if msg := client.Hub.farewell; msg != nil {
+    if data, err := client.encode([][]byte{msg}); err == nil {
+      client.Session.Write(data, client.framing.Binary())
     }
+  }
+  client.Session.CloseWith(websocket.CloseGoingAway, "Server shutting down")
+}
 
-    // no, not a timeout
-    // send upate selected player to all clients
-    playerSelect := struct { Game *game.Game `json:"game"`}{g}
+// Builds a frame, the sequence id is left blank and filled in as the
+// frame is queued for a client.
+func MakeMessage(header string, body []byte) ([]byte, error) {
+  return protocol.EncodeRaw(header, body)
+}
 
-    err = MarshalAndSendToGame(client, g, "PLAYER_SELECTED", playerSelect)
-    if err != nil {
-      SendError(client, err)
-      return
-    }
-  
+// MarshalAndSendToGame sends to the players of the game and anyone
+// spectating it.
+func MarshalAndSendToGame(hub Sender, g *game.Game, header string, body interface{}) (error) {
+  err := MarshalAndSendToRoom(hub, gameRoom(g.GameId), header, body)
+  if err != nil {
+    return err
+  }
 
-	  case "ANSWER":
-		reqFull := struct { Request string `json:"request"`
-							GameId string `json:"gameId"`
-							AnswerIndex uint8	`json:"index"`}{}
-		err := json.Unmarshal(msg[32:], &reqFull)
-		if err != nil {
-			SendError(client, err)
-		}
-		
-		// TODO: determine if the answer was correct and then send the
-		// answer message back to everyone
-		// call IncomingAnswer with no cliendId
-		correct, correctAnswer, g, err := game.IncomingAnswer(reqFull.GameId,
-																client.ClientId,
-																reqFull.AnswerIndex)
-		if err != nil {
-			SendError(client, err)
-		}
-		
-		// send answer response message
-		answerResp := struct { Correct bool `json:"correct"`
-							   CorrectAnswer int `json:"correctAnswer"`
-							   Game *game.Game `json:"game"`}{}				
-		answerResp.Correct = correct
-		answerResp.CorrectAnswer = correctAnswer
-		answerResp.Game = g
-		
-		err = MarshalAndSendToGame(client, g, "ANSWER_RESPONSE", answerResp)
-		if err != nil {
-		  SendError(client, err)
-		  return
-		}
-		
-		if g.State == game.ENDED {
-			game.RemoveGame(g.GameId)
-      // gls, err := game.AllGames()
-      // if err != nil {
-      //   SendError(client, err)
-      //   return
-      // }
-      // err = MarshalAndSend(client, "GAMES", gls, true);
-      // if err != nil {
-      //   SendError(client, err)
-      //   return
-      // }
-
-      return
-		}
-	  default:
-	    fmt.Println("unknown req type")
-	}
+  return MarshalAndSendToRoom(hub, spectatorRoom(g.GameId), header, body)
+}
 
-  default:
-    SendError(client, fmt.Errorf("Unknown message header %q", header))
+// MarshalAndSendToRoom sends to every client sitting in the room.
+func MarshalAndSendToRoom(hub Sender, room, header string, body interface{}) (error) {
+  msg, err := marshalMessage(header, body)
+  if err != nil {
+    return err
   }
+
+  hub.Publish(room, msg)
+  return nil
 }
 
-func MakeMessage(header string, body []byte) ([]byte, error) {
-  if len(header) > 32 {
-    return nil, errors.New("Invalid header")
+func MarshalAndSend(client *Client, header string, body interface{}) (error) {
+  fmt.Println("Sending message: ", header)
+  msg, err := marshalMessage(header, body)
+  if err != nil {
+    return err
   }
 
-  // pad the header
-  header = fmt.Sprintf("%-32s", header)
-  // prepend the header
-  msg := append([]byte(header), body...)
+  if !client.queue(msg) {
+    return errors.New("Send buffer full or closed")
+  }
+  return nil
+}
 
-  return msg, nil
+// Reply sends the direct reply to a request from the client, see Reply.
+func (c *Client) Reply(requestId, header string, body interface{}) error {
+  return Reply(c, requestId, header, body)
 }
 
-func MarshalAndSendToGame(client *Client, g *game.Game, header string, body interface{}) (error) {
-  // TODO: impl
-  for _, p := range g.Players {
-    err := MarshalAndSend(client.Hub.clients[p.PlayerId], header, body, false)
-    if err != nil {
-      return err
-    }
+// Reply sends the direct reply to a request from the client, echoing the
+// requestId in the body.
+func Reply(client *Client, requestId, header string, body interface{}) (error) {
+  fmt.Println("Sending reply: ", header)
+  mbytes, err := json.Marshal(body)
+  if err != nil {
+    return err
   }
 
+  msg, err := MakeMessage(header, protocol.WithRequestId(mbytes, requestId))
+  if err != nil {
+    return err
+  }
+
+  client.record(requestId, msg)
+  if !client.queue(msg) {
+    return errors.New("Send buffer full or closed")
+  }
   return nil
 }
 
-func MarshalAndSend(client *Client, header string, body interface{}, broadcast bool) (error) {
-  
-  fmt.Println("Sending message: ", header)
-      // send the start wait message
-      mbytes, err := json.Marshal(body)
-
-      if err != nil {
-        return err
-      }
-	  fmt.Println(string(mbytes))
-
-      msg, err := MakeMessage(header, mbytes)
+func marshalMessage(header string, body interface{}) ([]byte, error) {
+  mbytes, err := json.Marshal(body)
+  if err != nil {
+    return nil, err
+  }
+  fmt.Println(string(mbytes))
 
-      if (broadcast) {
-        client.Hub.broadcast <- msg
-        return nil
-      }
+  return MakeMessage(header, mbytes)
+}
 
-      client.Send <- msg
-      return nil
+// closeGameRooms sends the spectators and anyone still seated back to
+// the lobby once a game is gone.
+func closeGameRooms(hub Sender, gameId string) {
+  hub.Dissolve(gameRoom(gameId))
+  hub.Dissolve(spectatorRoom(gameId))
 }
 
-func SendError(client *Client, err error) {
+// Sends an ERROR frame with the code for the error, requestId is the id
+// of the message that caused it, if it had one.
+func SendError(client *Client, requestId string, err error) {
   fmt.Println("Sending Error: ", err);
-  client.Send <- []byte(fmt.Sprintf("An error occured: %v", err))
+  // the requestId goes first, like in other replies
+  body, merr := json.Marshal(protocol.Error{
+    Code: errorCode(err),
+    Message: err.Error(),
+  })
+  if merr != nil {
+    log.Println("Could not marshal error: ", merr)
+    return
+  }
+  msg, merr := MakeMessage("ERROR", protocol.WithRequestId(body, requestId))
+  if merr != nil {
+    log.Println("Could not marshal error: ", merr)
+    return
+  }
+
+  client.record(requestId, msg)
+  client.queue(msg)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gogo-sockets/protocol"
+)
+
+// client calls the admin API.
+type client struct {
+	server string
+	token  string
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// do sends the request, with body as JSON if it is not nil, and decodes
+// the reply into out. Error replies from the server are returned as errors.
+func (c *client) do(method, path string, body, out interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.server, "/")+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e protocol.Error
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Code == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
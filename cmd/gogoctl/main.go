@@ -0,0 +1,185 @@
+// gogoctl talks to the admin API of a running gogo-sockets server, and
+// checks question files before they are deployed.
+//
+//	gogoctl [flags] games
+//	gogoctl [flags] game <gameId>
+//	gogoctl [flags] kick <clientId>
+//	gogoctl [flags] announce [-game <gameId>] "message"
+//	gogoctl [flags] questions lint [dir]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"gogo-sockets/game"
+	"gogo-sockets/game/questions"
+	"gogo-sockets/protocol"
+)
+
+var server = flag.String("server", envOr("GOGOCTL_SERVER", "http://localhost:8080"), "server address, defaults to $GOGOCTL_SERVER")
+var token = flag.String("token", os.Getenv("GOGO_ADMIN_TOKEN"), "admin token, defaults to $GOGO_ADMIN_TOKEN")
+var asJSON = flag.Bool("json", false, "print JSON instead of tables")
+
+// Where the server reads the question files from, relative to the repo.
+var defaultQuestionDir = filepath.Join("game", "questions", "questions")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	c := &client{server: *server, token: *token}
+
+	var err error
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "games":
+		err = listGames(c)
+	case "game":
+		if len(args) != 1 {
+			fail("usage: gogoctl game <gameId>")
+		}
+		err = showGame(c, args[0])
+	case "kick":
+		if len(args) != 1 {
+			fail("usage: gogoctl kick <clientId>")
+		}
+		err = kick(c, args[0])
+	case "announce":
+		fs := flag.NewFlagSet("announce", flag.ExitOnError)
+		gameId := fs.String("game", "", "send to this game instead of the lobby")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			fail("usage: gogoctl announce [-game <gameId>] \"message\"")
+		}
+		err = announce(c, fs.Arg(0), *gameId)
+	case "questions":
+		if len(args) == 0 || args[0] != "lint" || len(args) > 2 {
+			fail("usage: gogoctl questions lint [dir]")
+		}
+		dir := defaultQuestionDir
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		err = lintQuestions(dir)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err.Error())
+	}
+}
+
+func listGames(c *client) error {
+	var gls []*game.Game
+	if err := c.do("GET", "/admin/games", nil, &gls); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(gls)
+	}
+	printGames(gls)
+	return nil
+}
+
+func showGame(c *client, gameId string) error {
+	var d protocol.GameDetail
+	if err := c.do("GET", "/admin/games/"+url.PathEscape(gameId), nil, &d); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(d)
+	}
+	printGame(d)
+	return nil
+}
+
+func kick(c *client, clientId string) error {
+	var res protocol.KickResult
+	if err := c.do("POST", "/admin/clients/"+url.PathEscape(clientId)+"/kick", nil, &res); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(res)
+	}
+	fmt.Printf("Kicked %s: %d connection(s) closed, seat given up: %v\n", res.ClientId, res.Connections, res.Seat)
+	return nil
+}
+
+func announce(c *client, message, gameId string) error {
+	req := protocol.Announcement{Message: message, GameId: gameId}
+	if err := c.do("POST", "/admin/announce", req, &req); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(req)
+	}
+	if gameId == "" {
+		fmt.Println("Announced to the lobby")
+	} else {
+		fmt.Println("Announced to game", gameId)
+	}
+	return nil
+}
+
+// lintQuestions checks the question files locally, no server is needed.
+// Exits with status 1 if there are problems.
+func lintQuestions(dir string) error {
+	problems, err := questions.Lint(dir)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(problems); err != nil {
+			return err
+		}
+	} else {
+		printProblems(problems)
+	}
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: gogoctl [flags] <command>
+
+commands:
+  games                               list the games in progress
+  game <gameId>                       show a game, its current question and buzzes
+  kick <clientId>                     disconnect a client and give up its seat
+  announce [-game <gameId>] "message" send an announcement to the lobby or a game
+  questions lint [dir]                check question files
+
+flags:`)
+	flag.PrintDefaults()
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, "gogoctl:", msg)
+	os.Exit(1)
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
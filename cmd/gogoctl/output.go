@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gogo-sockets/game"
+	"gogo-sockets/game/questions"
+	"gogo-sockets/protocol"
+)
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func printGames(gls []*game.Game) {
+	sort.Slice(gls, func(i, j int) bool { return gls[i].GameId < gls[j].GameId })
+
+	t := newTable()
+	fmt.Fprintln(t, "GAME\tSTATE\tPLAYERS\tREMAINING\tCURRENT")
+	for _, g := range gls {
+		names := make([]string, 0, len(g.Players))
+		for _, p := range g.Players {
+			names = append(names, p.Name)
+		}
+		fmt.Fprintf(t, "%s\t%s\t%s\t%d\t%s\n", g.GameId, g.State, strings.Join(names, ","), g.RemainingQuestions, g.CurrentPlayerId)
+	}
+	t.Flush()
+}
+
+func printGame(d protocol.GameDetail) {
+	g := d.Game
+	fmt.Printf("Game:       %s\n", g.GameId)
+	fmt.Printf("State:      %s\n", g.State)
+	fmt.Printf("Categories: %s\n", strings.Join(g.Categories, ", "))
+	fmt.Printf("Remaining:  %d\n\n", g.RemainingQuestions)
+
+	t := newTable()
+	fmt.Fprintln(t, "PLAYER\tNAME\tSCORE\tCURRENT\tCONNECTED")
+	for _, p := range g.Players {
+		fmt.Fprintf(t, "%s\t%s\t%d\t%v\t%v\n", p.PlayerId, p.Name, p.Score, p.PlayerId == g.CurrentPlayerId, !p.Disconnected)
+	}
+	t.Flush()
+
+	q := d.Question
+	if q == nil {
+		fmt.Println("\nNo question in play")
+		return
+	}
+
+	fmt.Printf("\nQuestion:   %s for %d\n", q.Category, q.PointValue)
+	fmt.Printf("            %s\n", q.Text)
+	for i, c := range q.Choices {
+		mark := " "
+		if uint8(i) == q.CorrectIndex {
+			mark = "*"
+		}
+		fmt.Printf("          %s %d. %s\n", mark, i, c)
+	}
+
+	if len(q.Buzzes) == 0 {
+		fmt.Println("\nNo buzzes")
+		return
+	}
+
+	fmt.Println()
+	t = newTable()
+	fmt.Fprintln(t, "BUZZ\tDELAY\tEXPIRED")
+	for _, b := range q.Buzzes {
+		fmt.Fprintf(t, "%s\t%dms\t%v\n", b.PlayerId, b.Delay, b.Expired)
+	}
+	t.Flush()
+}
+
+func printProblems(problems []questions.Problem) {
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+
+	t := newTable()
+	fmt.Fprintln(t, "FILE\tCATEGORY\tQUESTION\tPROBLEM")
+	for _, p := range problems {
+		question := "-"
+		if p.Question > 0 {
+			question = fmt.Sprint(p.Question)
+		}
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\n", p.File, p.Category, question, p.Message)
+	}
+	t.Flush()
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"gogo-sockets/protocol"
+)
+
+// Config is every tunable of the server. Each one is a flag, a setting in
+// the JSON config file named like the flag in camelCase, and a GOGO_
+// environment variable named like the flag in upper case: -pong-wait,
+// "pongWait" and GOGO_PONG_WAIT. The command line wins over the
+// environment, which wins over the file, which wins over the defaults.
+type Config struct {
+	Addr        string
+	QuestionDir string
+
+	// Who gets in.
+	AuthMode     string
+	AuthKey      string
+	AuthSecret   string
+	AuthKeysFile string
+
+	// Who may open a socket.
+	AllowedOrigins string
+	MaxConnsPerIP  int
+	MaxConns       int
+	BanAfter       int
+	BanFor         time.Duration
+
+	// Serving wss directly.
+	TLSCert      string
+	TLSKey       string
+	RedirectAddr string
+
+	// What to do with a second connection for a connected clientId.
+	DupPolicy string
+
+	// Live game management, empty disables the admin API.
+	AdminToken string
+
+	// Sockets. Pings go out every 9/10 of PongWait.
+	WriteWait       time.Duration
+	PongWait        time.Duration
+	MaxMessageSize  int64
+	HelloTimeout    time.Duration
+	HelloMaxSize    int64
+	SendBuffer      int
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Clients that cannot keep up.
+	Backpressure        string
+	SlowConsumerTimeout time.Duration
+	MaxOverflow         int
+
+	// How fast each client may send, all told and each kind of message.
+	ClientMsgRate  float64
+	ClientMsgBurst int
+	MsgRate        float64
+	MsgBurst       int
+	MsgLimits      string
+	AbuseStrikes   int
+	AbuseWindow    time.Duration
+
+	// Dropped players keep their seat this long.
+	SeatGrace time.Duration
+
+	// What a resuming client can be sent again: the frames it missed,
+	// and the replies to the requests it retries.
+	ReplaySize   int
+	DedupeWindow time.Duration
+	DedupeMax    int
+
+	// HTTP fallback, for networks that block websockets.
+	Fallback        bool
+	PollWait        time.Duration
+	FallbackIdle    time.Duration
+	StreamKeepAlive time.Duration
+	FallbackBuffer  int
+
+	// Probes, and certificate reloads.
+	HubProbeTimeout  time.Duration
+	CertPollInterval time.Duration
+
+	// Shutting down on SIGTERM.
+	ShutdownTimeout time.Duration
+	ReconnectAfter  time.Duration
+	SnapshotFile    string
+
+	// Names of the flags of the settings, see bind.
+	settings []string
+
+	// The policy of each message class, parsed from Backpressure by
+	// validate.
+	backpressure map[messageClass]slowPolicy
+
+	// The budgets of their own, parsed from MsgLimits by validate.
+	msgLimits map[string]budget
+}
+
+func defaultConfig() *Config {
+	c := &Config{
+		Addr:                ":8080",
+		QuestionDir:         filepath.Join("game", "questions", "questions"),
+		AuthMode:            "static",
+		AuthKeysFile:        "keys.txt",
+		MaxConnsPerIP:       20,
+		MaxConns:            10000,
+		BanAfter:            5,
+		BanFor:              10 * time.Minute,
+		DupPolicy:           "kick",
+		WriteWait:           10 * time.Second,
+		PongWait:            2 * time.Second,
+		MaxMessageSize:      512,
+		HelloTimeout:        3 * time.Second,
+		HelloMaxSize:        1024,
+		SendBuffer:          256,
+		ReadBufferSize:      1024,
+		WriteBufferSize:     1024,
+		Backpressure:        "lobby=coalesce,gameplay=wait",
+		SlowConsumerTimeout: 5 * time.Second,
+		MaxOverflow:         1024,
+		ClientMsgRate:       40,
+		ClientMsgBurst:      80,
+		MsgRate:             20,
+		MsgBurst:            40,
+		MsgLimits:           "GAME_REQ=1/5",
+		AbuseStrikes:        20,
+		AbuseWindow:         10 * time.Second,
+		SeatGrace:           30 * time.Second,
+		ReplaySize:          128,
+		DedupeWindow:        30 * time.Second,
+		DedupeMax:           64,
+		Fallback:            true,
+		PollWait:            25 * time.Second,
+		FallbackIdle:        10 * time.Second,
+		StreamKeepAlive:     15 * time.Second,
+		FallbackBuffer:      64,
+		HubProbeTimeout:     time.Second,
+		CertPollInterval:    10 * time.Second,
+		ShutdownTimeout:     10 * time.Second,
+		ReconnectAfter:      5 * time.Second,
+		SnapshotFile:        "games.snapshot.json",
+	}
+
+	// usable without load, as in tests
+	c.backpressure, _ = parseBackpressure(c.Backpressure)
+	c.msgLimits, _ = parseRateLimits(c.MsgLimits)
+	return c
+}
+
+// bind registers a flag for each setting.
+func (c *Config) bind(fs *flag.FlagSet) {
+	str := func(p *string, name, usage string) {
+		fs.StringVar(p, name, *p, usage)
+		c.settings = append(c.settings, name)
+	}
+	num := func(p *int, name, usage string) {
+		fs.IntVar(p, name, *p, usage)
+		c.settings = append(c.settings, name)
+	}
+	size := func(p *int64, name, usage string) {
+		fs.Int64Var(p, name, *p, usage)
+		c.settings = append(c.settings, name)
+	}
+	dur := func(p *time.Duration, name, usage string) {
+		fs.DurationVar(p, name, *p, usage)
+		c.settings = append(c.settings, name)
+	}
+
+	str(&c.Addr, "addr", "http service address")
+	str(&c.QuestionDir, "question-dir", "directory of the question category files")
+
+	str(&c.AuthMode, "auth", "authentication: static, token or file")
+	str(&c.AuthKey, "auth-key", "shared key for static auth")
+	str(&c.AuthSecret, "auth-secret", "signing secret for token auth")
+	str(&c.AuthKeysFile, "auth-keys-file", "file of \"tenant key\" lines for file auth")
+
+	str(&c.AllowedOrigins, "allowed-origins", "comma separated Origins allowed to connect, * for any, empty for same origin only")
+	num(&c.MaxConnsPerIP, "max-conns-per-ip", "most concurrent sockets per remote IP, 0 for no limit")
+	num(&c.MaxConns, "max-conns", "most concurrent sockets overall, 0 for no limit")
+	num(&c.BanAfter, "ban-after", "failed HELOs from an IP before it is banned, 0 never bans")
+	dur(&c.BanFor, "ban-for", "how long a ban lasts, and the window failures are counted in")
+
+	str(&c.TLSCert, "tls-cert", "certificate file, serves TLS on -addr when set with -tls-key")
+	str(&c.TLSKey, "tls-key", "private key file for -tls-cert")
+	str(&c.RedirectAddr, "redirect-addr", "plain http address redirecting to -addr, only with TLS")
+
+	str(&c.DupPolicy, "dup-policy", "second connection for a clientId: reject it, kick the old one or multi to keep both")
+	str(&c.AdminToken, "admin-token", "bearer token for the /admin API, empty disables it")
+
+	dur(&c.WriteWait, "write-wait", "time allowed to write a message to a client")
+	dur(&c.PongWait, "pong-wait", "time allowed to read the next pong from a client, pings go out every 9/10 of it")
+	size(&c.MaxMessageSize, "max-message-size", "longest message a client may send, in bytes")
+	dur(&c.HelloTimeout, "hello-timeout", "time allowed for the HELO after a socket opens")
+	size(&c.HelloMaxSize, "hello-max-size", "longest HELO a client may send, in bytes")
+	num(&c.SendBuffer, "send-buffer", "messages queued for a client before backpressure kicks in")
+	num(&c.ReadBufferSize, "read-buffer-size", "websocket read buffer size, in bytes")
+	num(&c.WriteBufferSize, "write-buffer-size", "websocket write buffer size, in bytes")
+
+	str(&c.Backpressure, "backpressure", "what to do with messages for a client whose buffer is full, per class: coalesce, wait or evict")
+	dur(&c.SlowConsumerTimeout, "slow-consumer-timeout", "how long a client may hold messages back before it is disconnected, it may resume")
+	num(&c.MaxOverflow, "max-overflow", "most messages held back for a client, past this it is disconnected whatever the policy")
+
+	fs.Float64Var(&c.ClientMsgRate, "client-msg-rate", c.ClientMsgRate, "messages a second each client may send, all types together")
+	c.settings = append(c.settings, "client-msg-rate")
+	num(&c.ClientMsgBurst, "client-msg-burst", "messages each client may send in a burst, all types together")
+	fs.Float64Var(&c.MsgRate, "msg-rate", c.MsgRate, "messages a second each client may send per message type")
+	c.settings = append(c.settings, "msg-rate")
+	num(&c.MsgBurst, "msg-burst", "messages each client may send per message type in a burst")
+	str(&c.MsgLimits, "msg-limits", "budgets of their own for some message types, as type=rate/burst, comma separated")
+	num(&c.AbuseStrikes, "abuse-strikes", "rate limited messages after which a client is disconnected, 0 never disconnects")
+	dur(&c.AbuseWindow, "abuse-window", "the window -abuse-strikes are counted in")
+
+	dur(&c.SeatGrace, "seat-grace", "how long a dropped player keeps their seat")
+	num(&c.ReplaySize, "replay-size", "sent messages kept per client, to resend when it resumes")
+	dur(&c.DedupeWindow, "dedupe-window", "how long the replies to a request are kept to answer retries")
+	num(&c.DedupeMax, "dedupe-max", "most requests remembered per client to answer retries")
+
+	fs.BoolVar(&c.Fallback, "fallback", c.Fallback, "serve the Server-Sent Events and long-poll fallback under /fallback/")
+	c.settings = append(c.settings, "fallback")
+	dur(&c.PollWait, "poll-wait", "how long a long poll waits for a message before answering empty")
+	dur(&c.FallbackIdle, "fallback-idle", "how long an HTTP session may go without an event stream or a poll")
+	dur(&c.StreamKeepAlive, "stream-keep-alive", "how often idle event streams get a comment, so proxies keep them open")
+	num(&c.FallbackBuffer, "fallback-buffer", "messages queued for an HTTP session between polls")
+
+	dur(&c.HubProbeTimeout, "hub-probe-timeout", "how long the hub has to answer a health probe")
+	dur(&c.CertPollInterval, "cert-poll-interval", "how often the TLS certificate files are checked for changes")
+
+	dur(&c.ShutdownTimeout, "shutdown-timeout", "how long to wait for sockets to flush on shutdown")
+	dur(&c.ReconnectAfter, "reconnect-after", "how long clients are told to wait before reconnecting after a shutdown")
+	str(&c.SnapshotFile, "snapshot-file", "games in progress are written here on shutdown and restored on start, empty to disable")
+}
+
+// load applies the config file, if there is one, and the environment to
+// the settings, keeping the ones given on the command line, then checks
+// the result. The flags must have been parsed.
+func (c *Config) load(path string, fs *flag.FlagSet) error {
+	given := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	if path != "" {
+		if err := c.loadFile(path, fs); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range c.settings {
+		if v, ok := os.LookupEnv(envName(name)); ok {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s: invalid value %q: %v", envName(name), v, err)
+			}
+		}
+	}
+
+	for name, v := range given {
+		fs.Set(name, v)
+	}
+
+	return c.validate()
+}
+
+// loadFile reads a JSON object of settings. Values are given as they
+// would be on the command line, numbers and booleans may be JSON ones
+// and lists like allowedOrigins JSON arrays.
+func (c *Config) loadFile(path string, fs *flag.FlagSet) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&settings); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	names := map[string]string{}
+	for _, name := range c.settings {
+		names[fileKey(name)] = name
+	}
+
+	for key, v := range settings {
+		name, ok := names[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+
+		value := fmt.Sprint(v)
+		if list, ok := v.([]interface{}); ok {
+			parts := make([]string, len(list))
+			for i, p := range list {
+				parts[i] = fmt.Sprint(p)
+			}
+			value = strings.Join(parts, ",")
+		}
+
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: invalid value %q: %v", path, key, value, err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the settings make sense together, and parses the ones
+// that need it.
+func (c *Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Addr != "", "addr must be set")
+	check(c.WriteWait > 0, "write-wait must be positive")
+	check(c.PongWait > 0, "pong-wait must be positive")
+	check(c.HelloTimeout > 0, "hello-timeout must be positive")
+	check(c.MaxMessageSize >= protocol.HeaderLen, "max-message-size must be at least %d", protocol.HeaderLen)
+	check(c.HelloMaxSize >= protocol.HeaderLen, "hello-max-size must be at least %d", protocol.HeaderLen)
+	check(c.SendBuffer > 0, "send-buffer must be positive")
+	check(c.ReadBufferSize > 0 && c.WriteBufferSize > 0, "websocket buffer sizes must be positive")
+	check(c.MaxConnsPerIP >= 0 && c.MaxConns >= 0, "connection limits must not be negative")
+	check(c.BanAfter <= 0 || c.BanFor > 0, "ban-for must be positive when ban-after is set")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls-cert and tls-key go together")
+	check(c.RedirectAddr == "" || c.TLSCert != "", "redirect-addr needs TLS")
+	check(c.SlowConsumerTimeout > 0, "slow-consumer-timeout must be positive")
+	check(c.MaxOverflow > 0, "max-overflow must be positive")
+	check(c.ClientMsgRate > 0, "client-msg-rate must be positive")
+	check(c.ClientMsgBurst > 0, "client-msg-burst must be positive")
+	check(c.MsgRate > 0, "msg-rate must be positive")
+	check(c.MsgBurst > 0, "msg-burst must be positive")
+	check(c.AbuseStrikes <= 0 || c.AbuseWindow > 0, "abuse-window must be positive when abuse-strikes is set")
+	check(c.SeatGrace >= 0, "seat-grace must not be negative")
+	check(c.ReplaySize > 0, "replay-size must be positive")
+	check(c.DedupeWindow > 0, "dedupe-window must be positive")
+	check(c.DedupeMax > 0, "dedupe-max must be positive")
+	check(c.PollWait > 0, "poll-wait must be positive")
+	check(c.FallbackIdle > 0, "fallback-idle must be positive")
+	check(c.StreamKeepAlive > 0, "stream-keep-alive must be positive")
+	check(c.FallbackBuffer > 0, "fallback-buffer must be positive")
+	check(c.HubProbeTimeout > 0, "hub-probe-timeout must be positive")
+	check(c.CertPollInterval > 0, "cert-poll-interval must be positive")
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	check(c.ReconnectAfter >= 0, "reconnect-after must not be negative")
+
+	if _, err := ParseDupPolicy(c.DupPolicy); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if budgets, err := parseRateLimits(c.MsgLimits); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		c.msgLimits = budgets
+	}
+	if policies, err := parseBackpressure(c.Backpressure); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		c.backpressure = policies
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// pingPeriod is how often clients are pinged, often enough for a pong to
+// come back within PongWait.
+func (c *Config) pingPeriod() time.Duration {
+	return (c.PongWait * 9) / 10
+}
+
+// envName is the environment variable of the flag: max-conns is
+// GOGO_MAX_CONNS.
+func envName(name string) string {
+	return "GOGO_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// fileKey is the config file key of the flag: max-conns is maxConns.
+func fileKey(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		r := []rune(parts[i])
+		r[0] = unicode.ToUpper(r[0])
+		parts[i] = string(r)
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"pongWait": "4s",
+		"sendBuffer": 32,
+		"maxConns": 7,
+		"allowedOrigins": ["https://a.example", "https://b.example"]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GOGO_SEND_BUFFER", "64")
+	t.Setenv("GOGO_MAX_CONNS", "8")
+
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.bind(fs)
+	if err := fs.Parse([]string{"-max-conns", "9"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.load(path, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		setting string
+		got     interface{}
+		want    interface{}
+	}{
+		{"default", cfg.WriteWait, 10 * time.Second},
+		{"file over default", cfg.PongWait, 4 * time.Second},
+		{"file list", cfg.AllowedOrigins, "https://a.example,https://b.example"},
+		{"env over file", cfg.SendBuffer, 64},
+		{"flag over env", cfg.MaxConns, 9},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestConfigLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown setting", file: `{"pongWiat": "1s"}`, want: `unknown setting "pongWiat"`},
+		{name: "bad file value", file: `{"pongWait": "soon"}`, want: `invalid value "soon"`},
+		{name: "bad env value", env: map[string]string{"GOGO_SEND_BUFFER": "lots"}, want: "GOGO_SEND_BUFFER"},
+		{name: "out of range", env: map[string]string{"GOGO_REPLAY_SIZE": "0"}, want: "replay-size must be positive"},
+		{name: "bad backpressure", env: map[string]string{"GOGO_BACKPRESSURE": "lobby=drop"}, want: `Unknown backpressure policy "drop"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = filepath.Join(t.TempDir(), "config.json")
+				if err := ioutil.WriteFile(path, []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := defaultConfig()
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cfg.bind(fs)
+			fs.Parse(nil)
+
+			err := cfg.load(path, fs)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("load() = %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gogo-sockets/protocol"
+)
+
+// replyCache remembers the direct replies to a client's recent requests,
+// by requestId, so a retried request is answered again instead of being
+// run twice. It is carried over when the client resumes.
+type replyCache struct {
+	// How long the replies to a request are kept, and for how many
+	// requests at most.
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	entries map[string]*cachedReply
+}
+
+type cachedReply struct {
+	at     time.Time
+	frames [][]byte
+}
+
+func newReplyCache(window time.Duration, max int) *replyCache {
+	return &replyCache{window: window, max: max, entries: make(map[string]*cachedReply)}
+}
+
+// lookup returns the replies sent to the request, if it is still
+// remembered.
+func (rc *replyCache) lookup(requestId string) ([][]byte, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	e, ok := rc.entries[requestId]
+	if !ok || time.Since(e.at) > rc.window {
+		return nil, false
+	}
+
+	return e.frames, true
+}
+
+// store remembers the replies sent to the request, forgetting expired
+// requests, or the oldest one once full.
+func (rc *replyCache) store(requestId string, frames [][]byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var oldestId string
+	var oldest time.Time
+	for id, e := range rc.entries {
+		if time.Since(e.at) > rc.window {
+			delete(rc.entries, id)
+			continue
+		}
+		if oldestId == "" || e.at.Before(oldest) {
+			oldestId, oldest = id, e.at
+		}
+	}
+
+	if len(rc.entries) >= rc.max {
+		delete(rc.entries, oldestId)
+	}
+
+	rc.entries[requestId] = &cachedReply{at: time.Now(), frames: frames}
+}
+
+// pendingRequest collects the direct replies to the request being
+// handled, cache says whether they are kept for retries.
+type pendingRequest struct {
+	id     string
+	frames [][]byte
+	cache  bool
+}
+
+// beginRequest starts collecting the replies to the request.
+func (c *Client) beginRequest(requestId string) {
+	c.pending = &pendingRequest{id: requestId}
+}
+
+// finishRequest acknowledges a request that got no direct reply and
+// remembers the replies of the ones that must not run twice.
+func (c *Client) finishRequest() {
+	p := c.pending
+	if len(p.frames) == 0 {
+		Reply(c, p.id, "ACK", protocol.Ack{})
+	}
+	c.pending = nil
+
+	if p.cache {
+		c.replies.store(p.id, p.frames)
+	}
+}
+
+// idempotent is the middleware for messages that would otherwise create,
+// join or score twice: retries are answered from the reply cache instead
+// of being handled again.
+func idempotent(next Handler) Handler {
+	return func(r *Request) error {
+		// only clients on a session keep their replies
+		c, ok := r.Client.(*Client)
+		if !ok || c.pending == nil {
+			return next(r)
+		}
+
+		if frames, ok := c.replies.lookup(r.Id); ok {
+			log.Println("Duplicate request: ", r.Id)
+			for _, frame := range frames {
+				c.queue(frame)
+			}
+			c.pending.frames = frames
+			return nil
+		}
+
+		c.pending.cache = true
+		return next(r)
+	}
+}
+
+// record keeps a direct reply to the request being handled.
+func (c *Client) record(requestId string, msg []byte) {
+	if c.pending != nil && requestId != "" && c.pending.id == requestId {
+		c.pending.frames = append(c.pending.frames, msg)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// Codes for errors caused by the shape of what a client sent, the game
+// package has its own codes for the state of the games.
+const (
+	errBadLength      = protocol.CodeBadLength
+	errBadBody        = protocol.CodeBadBody
+	errUnknownHeader  = protocol.CodeUnknownHeader
+	errUnknownRequest = protocol.CodeUnknownRequest
+)
+
+// clientError is a malformed or unknown message from a client.
+type clientError struct {
+	code string
+	msg  string
+}
+
+func (e *clientError) Error() string {
+	return e.msg
+}
+
+func newClientError(code, format string, args ...interface{}) *clientError {
+	return &clientError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorCode maps an error to the code sent to the client.
+func errorCode(err error) string {
+	var ge *game.Error
+	if errors.As(err, &ge) {
+		return string(ge.Code)
+	}
+
+	var ce *clientError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	if errors.Is(err, protocol.ErrShortFrame) {
+		return errBadLength
+	}
+
+	var be *protocol.BodyError
+	if errors.As(err, &be) {
+		return errBadBody
+	}
+
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &se) || errors.As(err, &te) {
+		return errBadBody
+	}
+
+	return string(game.Internal)
+}
+
+// requestIdOf digs the optional requestId out of a message body, so errors
+// can be matched up with the message that caused them.
+func requestIdOf(body []byte) string {
+	req := struct {
+		RequestId string `json:"requestId"`
+	}{}
+
+	// anything that is not an object simply has no id
+	json.Unmarshal(body, &req)
+	return req.RequestId
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Content type of binary messages, in the msgpack framing.
+const binaryContentType = "application/msgpack"
+
+var errSessionClosed = errors.New("Session closed")
+
+// fallbackAPI is the HTTP fallback for networks that block websockets.
+// Messages are the same as on a websocket, in any framing, one per
+// request body. Binary ones are sent as application/msgpack.
+//
+//	POST   /fallback/session             the HELO, the reply is {"session"}
+//	GET    /fallback/events?session=     server messages as Server-Sent Events
+//	GET    /fallback/poll?session=       the next server message, long polled
+//	POST   /fallback/send?session=       a message to the server
+//	DELETE /fallback/session?session=    close the session
+//
+// Event streams send text messages as "message" events and binary ones
+// base64 encoded as "binary" events, with a comment every StreamKeepAlive
+// so proxies keep them open. Polls answer 204 when nothing came within
+// PollWait. Once the session is closed both say why with {"code",
+// "reason"}, as a "close" event or a 410 reply.
+type fallbackAPI struct {
+	hub *Hub
+}
+
+// closeNotice is why a session closed, as in a websocket close frame.
+type closeNotice struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (f *fallbackAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.hub.guard.checkOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/fallback"), "/")
+
+	if path == "session" && r.Method == http.MethodPost {
+		f.open(w, r)
+		return
+	}
+
+	routes := map[string]struct {
+		method string
+		handle func(http.ResponseWriter, *http.Request, *httpSession)
+	}{
+		"session": {http.MethodDelete, f.close},
+		"events":  {http.MethodGet, f.events},
+		"poll":    {http.MethodGet, f.poll},
+		"send":    {http.MethodPost, f.send},
+	}
+	route, ok := routes[path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != route.method {
+		http.Error(w, "Use "+route.method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := httpSessions.get(r.URL.Query().Get("session"))
+	if s == nil {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+	route.handle(w, r, s)
+}
+
+// open starts a session with the HELO in the body. Like serveWs, but the
+// peer reads what the server sends, WELCOME first, from events or polls.
+func (f *fallbackAPI) open(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&draining) == 1 {
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip, ok := admitConn(f.hub, w, r)
+	if !ok {
+		return
+	}
+
+	msg, ok := readBody(w, r, f.hub.cfg.HelloMaxSize)
+	if !ok {
+		f.hub.guard.release(ip)
+		return
+	}
+
+	s := newHTTPSession(f.hub.cfg)
+	s.inbox <- msg
+	httpSessions.add(s)
+
+	if !authAndRegister(f.hub, s, ip) {
+		f.hub.guard.release(ip)
+		code, reason := s.closeStatus()
+		if code == 0 {
+			reason = "Handshake refused"
+		}
+		writeFallbackJSON(w, http.StatusForbidden, closeNotice{Code: code, Reason: reason})
+		return
+	}
+
+	writeFallbackJSON(w, http.StatusOK, map[string]string{"session": s.id})
+}
+
+func (f *fallbackAPI) close(w http.ResponseWriter, r *http.Request, s *httpSession) {
+	s.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fallbackAPI) send(w http.ResponseWriter, r *http.Request, s *httpSession) {
+	msg, ok := readBody(w, r, f.hub.cfg.MaxMessageSize)
+	if !ok {
+		return
+	}
+
+	select {
+	case s.inbox <- msg:
+		w.WriteHeader(http.StatusNoContent)
+	case <-s.done:
+		s.writeClosed(w)
+	case <-r.Context().Done():
+	}
+}
+
+// poll answers with the next message for the peer, or 204 if none came in
+// time.
+func (f *fallbackAPI) poll(w http.ResponseWriter, r *http.Request, s *httpSession) {
+	s.attach()
+	defer s.detach()
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	select {
+	case msg := <-s.outbox:
+		writeMessage(w, msg)
+	case <-s.done:
+		// what was sent before the close goes first
+		select {
+		case msg := <-s.outbox:
+			writeMessage(w, msg)
+		default:
+			s.writeClosed(w)
+		}
+	case <-time.After(f.hub.cfg.PollWait):
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+// events streams the messages for the peer until the session closes or
+// the peer goes away. Messages taken off the session but not delivered
+// are lost with the stream, the peer resumes from its last sequence id.
+func (f *fallbackAPI) events(w http.ResponseWriter, r *http.Request, s *httpSession) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	s.attach()
+	defer s.detach()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(f.hub.cfg.StreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case msg := <-s.outbox:
+			if writeEvent(w, msg) != nil {
+				return
+			}
+		case <-s.done:
+			for n := len(s.outbox); n > 0; n-- {
+				writeEvent(w, <-s.outbox)
+			}
+			code, reason := s.closeStatus()
+			data, _ := json.Marshal(closeNotice{Code: code, Reason: reason})
+			fmt.Fprintf(w, "event: close\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// httpMessage is a message of an HTTP session, binary ones are in the
+// msgpack framing.
+type httpMessage struct {
+	data   []byte
+	binary bool
+}
+
+// httpSession is a Session over HTTP requests: the peer POSTs its
+// messages, and reads the server's from an event stream or long polls.
+type httpSession struct {
+	id string
+
+	// The settings: how long the peer has to take a message, and to
+	// come back for the next ones.
+	cfg *Config
+
+	// Messages from the peer, and for it.
+	inbox  chan httpMessage
+	outbox chan httpMessage
+
+	// Closed with the session.
+	done chan struct{}
+	once sync.Once
+
+	mu sync.Mutex
+
+	// Event streams and polls open, and when the last one ended.
+	readers  int
+	lastSeen time.Time
+
+	// Why the session closed, see CloseWith.
+	closeCode int
+	closeText string
+}
+
+func newHTTPSession(cfg *Config) *httpSession {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return &httpSession{
+		id:       hex.EncodeToString(id),
+		cfg:      cfg,
+		inbox:    make(chan httpMessage, 1),
+		outbox:   make(chan httpMessage, cfg.FallbackBuffer),
+		done:     make(chan struct{}),
+		lastSeen: time.Now(),
+	}
+}
+
+func (s *httpSession) Read() ([]byte, bool, error) {
+	select {
+	case msg := <-s.inbox:
+		return msg.data, msg.binary, nil
+	case <-s.done:
+		return nil, false, errSessionClosed
+	}
+}
+
+func (s *httpSession) Write(msg []byte, binary bool) error {
+	timer := time.NewTimer(s.cfg.WriteWait)
+	defer timer.Stop()
+
+	select {
+	case s.outbox <- httpMessage{data: msg, binary: binary}:
+		return nil
+	case <-s.done:
+		return errSessionClosed
+	case <-timer.C:
+		return errors.New("Peer is not reading")
+	}
+}
+
+// Ping fails once no stream or poll has been open for -fallback-idle, as
+// a websocket that stopped answering pings would.
+func (s *httpSession) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readers == 0 && time.Since(s.lastSeen) > s.cfg.FallbackIdle {
+		return errors.New("Peer stopped polling")
+	}
+	return nil
+}
+
+func (s *httpSession) CloseWith(code int, reason string) error {
+	s.mu.Lock()
+	if s.closeCode == 0 {
+		s.closeCode, s.closeText = code, reason
+	}
+	s.mu.Unlock()
+
+	return s.Close()
+}
+
+// Close ends the session. It stays known for FallbackIdle, so the peer's
+// next poll learns why.
+func (s *httpSession) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		time.AfterFunc(s.cfg.FallbackIdle, func() { httpSessions.remove(s.id) })
+	})
+	return nil
+}
+
+func (s *httpSession) closeStatus() (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeCode, s.closeText
+}
+
+// attach and detach count the event streams and polls of the peer.
+func (s *httpSession) attach() {
+	s.mu.Lock()
+	s.readers++
+	s.mu.Unlock()
+}
+
+func (s *httpSession) detach() {
+	s.mu.Lock()
+	s.readers--
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *httpSession) writeClosed(w http.ResponseWriter) {
+	code, reason := s.closeStatus()
+	writeFallbackJSON(w, http.StatusGone, closeNotice{Code: code, Reason: reason})
+}
+
+// sessionTable is every open HTTP session, by id.
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+var httpSessions = &sessionTable{sessions: map[string]*httpSession{}}
+
+func (t *sessionTable) add(s *httpSession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sessions[s.id] = s
+}
+
+func (t *sessionTable) get(id string) *httpSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.sessions[id]
+}
+
+func (t *sessionTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.sessions, id)
+}
+
+// readBody reads a message of at most limit bytes from the request.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) (httpMessage, bool) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, "Message too long", http.StatusRequestEntityTooLarge)
+		return httpMessage{}, false
+	}
+
+	return httpMessage{data: data, binary: r.Header.Get("Content-Type") == binaryContentType}, true
+}
+
+func writeMessage(w http.ResponseWriter, msg httpMessage) {
+	if msg.binary {
+		w.Header().Set("Content-Type", binaryContentType)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(msg.data)
+}
+
+// writeEvent writes the message as a Server-Sent Event. Lines of batched
+// frames each get a data field, the peer joins them back with newlines.
+func writeEvent(w io.Writer, msg httpMessage) error {
+	if msg.binary {
+		_, err := fmt.Fprintf(w, "event: binary\ndata: %s\n\n", base64.StdEncoding.EncodeToString(msg.data))
+		return err
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(string(msg.data), "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFallbackJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Could not write fallback response: ", err)
+	}
+}
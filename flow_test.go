@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gogo-sockets/game"
+	"gogo-sockets/game/questions"
+	"gogo-sockets/protocol"
+)
+
+const testKey = "test-key"
+
+var setupOnce sync.Once
+
+// testSetup sets up what main sets up for the handlers and the hubs: the
+// message router and the questions.
+func testSetup(t *testing.T) {
+	t.Helper()
+
+	setupOnce.Do(func() {
+		cfg := defaultConfig()
+		messages = newMessageRouter()
+
+		questions.SetQuestionDir(cfg.QuestionDir)
+		questions.PopulateCategories()
+	})
+	if !questions.CategoriesInitialized {
+		t.Fatal("Could not load the question categories")
+	}
+}
+
+// testHub starts a hub with the default settings, letting in clients with
+// testKey.
+func testHub(t *testing.T) *Hub {
+	t.Helper()
+
+	testSetup(t)
+	cfg := defaultConfig()
+	cfg.AuthKey = testKey
+	hub, err := newHub(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hub.run()
+	return hub
+}
+
+// testPeer is a client connected over a memSession, in the legacy
+// framing.
+type testPeer struct {
+	t    *testing.T
+	name string
+	s    *memSession
+
+	// Frames of a batch not read yet.
+	pending []protocol.Frame
+}
+
+func connectPeer(t *testing.T, hub *Hub, clientId string) *testPeer {
+	t.Helper()
+
+	s, err := connectMemory(hub, protocol.Hello{Key: testKey, ClientId: clientId})
+	if err != nil {
+		t.Fatalf("%s: could not connect: %v", clientId, err)
+	}
+	return &testPeer{t: t, name: clientId, s: s}
+}
+
+func (p *testPeer) send(header string, body interface{}) {
+	p.t.Helper()
+
+	msg, err := protocol.Encode(header, body)
+	if err != nil {
+		p.t.Fatalf("%s: could not encode %s: %v", p.name, header, err)
+	}
+	if err := p.s.Send(msg); err != nil {
+		p.t.Fatalf("%s: could not send %s: %v", p.name, header, err)
+	}
+}
+
+// sync waits for the handlers of what the peer sent so far to finish:
+// the readPump handles one message at a time, so once a message with no
+// route is refused the ones before it are done.
+func (p *testPeer) sync() {
+	p.t.Helper()
+
+	p.send("SYNC", struct{}{})
+
+	// what came before the refusal is still to be expected
+	var kept []protocol.Frame
+	for {
+		f := p.next("ERROR")
+		if f.Header == "ERROR" {
+			p.pending = append(kept, p.pending...)
+			return
+		}
+		kept = append(kept, f)
+	}
+}
+
+// next is the next frame for the peer, waiting reports what for.
+func (p *testPeer) next(waiting string) protocol.Frame {
+	p.t.Helper()
+
+	if len(p.pending) == 0 {
+		msg, err := p.s.Next(2 * time.Second)
+		if err != nil {
+			p.t.Fatalf("%s: waiting for %s: %v", p.name, waiting, err)
+		}
+		for _, line := range bytes.Split(msg, []byte("\n")) {
+			f, err := protocol.Decode(line)
+			if err != nil {
+				p.t.Fatalf("%s: bad frame %q: %v", p.name, line, err)
+			}
+			p.pending = append(p.pending, f)
+		}
+	}
+
+	f := p.pending[0]
+	p.pending = p.pending[1:]
+	return f
+}
+
+// expect skips frames until one with the header, and decodes its body
+// into v unless v is nil.
+func (p *testPeer) expect(header string, v interface{}) {
+	p.t.Helper()
+
+	for {
+		f := p.next(header)
+		if f.Header != header {
+			continue
+		}
+
+		if v != nil {
+			if err := json.Unmarshal(f.Body, v); err != nil {
+				p.t.Fatalf("%s: bad %s body %s: %v", p.name, header, f.Body, err)
+			}
+		}
+		return
+	}
+}
+
+func TestGameFlow(t *testing.T) {
+	hub := testHub(t)
+
+	id := func(name string) string { return fmt.Sprintf("%s-%d", name, time.Now().UnixNano()) }
+	peers := map[string]*testPeer{}
+	for _, name := range []string{"a", "b", "c"} {
+		peers[name] = connectPeer(t, hub, id(name))
+		peers[name].expect("GAMES", nil)
+	}
+
+	var g game.Game
+	var q protocol.QuestionResponse
+	var selected protocol.PlayerSelected
+	current := func() *testPeer {
+		for _, p := range peers {
+			if p.name == g.CurrentPlayerId {
+				return p
+			}
+		}
+		t.Fatalf("No peer is the current player %q", g.CurrentPlayerId)
+		return nil
+	}
+
+	steps := []struct {
+		name string
+		from func() *testPeer
+		send string
+		body func() interface{}
+
+		// The message each peer must get, decoded into the value, if any.
+		want map[string]string
+		into interface{}
+	}{
+		{
+			name: "create",
+			from: func() *testPeer { return peers["a"] },
+			send: protocol.HeaderGameReq,
+			body: func() interface{} {
+				return protocol.GameRequest{Action: protocol.ActionCreate, Name: "A", NumCategories: 3, QuestionsPerCategory: 5, TotalQuestions: 2}
+			},
+			want: map[string]string{"a": "START_WAIT"},
+			into: &g,
+		},
+		{
+			name: "first join",
+			from: func() *testPeer { return peers["b"] },
+			send: protocol.HeaderGameReq,
+			body: func() interface{} {
+				return protocol.GameRequest{Action: protocol.ActionJoin, GameId: g.GameId, Name: "B"}
+			},
+			want: map[string]string{"a": "START_WAIT", "b": "START_WAIT"},
+		},
+		{
+			name: "second join starts the round",
+			from: func() *testPeer { return peers["c"] },
+			send: protocol.HeaderGameReq,
+			body: func() interface{} {
+				return protocol.GameRequest{Action: protocol.ActionJoin, GameId: g.GameId, Name: "C"}
+			},
+			want: map[string]string{"a": "START_ROUND", "b": "START_ROUND", "c": "START_ROUND"},
+			into: &g,
+		},
+		{
+			name: "spin",
+			from: current,
+			send: protocol.HeaderGameplay,
+			body: func() interface{} {
+				return protocol.WheelSpin{Gameplay: protocol.Gameplay{Request: protocol.RequestWheelSpin, GameId: g.GameId}, SpinFactor: 3}
+			},
+			want: map[string]string{"a": "WHEEL_SPUN", "b": "WHEEL_SPUN", "c": "WHEEL_SPUN"},
+		},
+		{
+			name: "select",
+			from: current,
+			send: protocol.HeaderGameplay,
+			body: func() interface{} {
+				return protocol.QuestionSelect{Gameplay: protocol.Gameplay{Request: protocol.RequestQuestionSelect, GameId: g.GameId}, Category: g.Categories[0], PointValue: 10}
+			},
+			want: map[string]string{"a": "QUESTION_RESPONSE", "b": "QUESTION_RESPONSE", "c": "QUESTION_RESPONSE"},
+			into: &q,
+		},
+		{
+			name: "first buzz",
+			from: func() *testPeer { return peers["a"] },
+			send: protocol.HeaderGameplay,
+			body: func() interface{} { return buzz(g.GameId, 300) },
+			want: map[string]string{"a": "BUZZED", "b": "BUZZED", "c": "BUZZED"},
+		},
+		{
+			name: "second buzz",
+			from: func() *testPeer { return peers["b"] },
+			send: protocol.HeaderGameplay,
+			body: func() interface{} { return buzz(g.GameId, 100) },
+			want: map[string]string{"a": "BUZZED", "b": "BUZZED", "c": "BUZZED"},
+		},
+		{
+			name: "last buzz selects the fastest",
+			from: func() *testPeer { return peers["c"] },
+			send: protocol.HeaderGameplay,
+			body: func() interface{} { return buzz(g.GameId, 200) },
+			want: map[string]string{"a": "PLAYER_SELECTED", "b": "PLAYER_SELECTED", "c": "PLAYER_SELECTED"},
+			into: &selected,
+		},
+		{
+			name: "answer",
+			from: func() *testPeer { return peers["b"] },
+			send: protocol.HeaderGameplay,
+			body: func() interface{} {
+				return protocol.Answer{Gameplay: protocol.Gameplay{Request: protocol.RequestAnswer, GameId: g.GameId}, AnswerIndex: 0}
+			},
+			want: map[string]string{"a": "ANSWER_RESPONSE", "b": "ANSWER_RESPONSE", "c": "ANSWER_RESPONSE"},
+		},
+	}
+
+	for _, step := range steps {
+		// handlers of different clients share the game, the next step
+		// must not start while this one is still sending it
+		from := step.from()
+		from.send(step.send, step.body())
+		from.sync()
+
+		// every peer gets the same body
+		for name, header := range step.want {
+			peers[name].expect(header, step.into)
+		}
+		if t.Failed() {
+			t.Fatalf("step %q failed", step.name)
+		}
+	}
+
+	if selected.Game == nil || selected.Game.CurrentPlayerId != peers["b"].name {
+		t.Errorf("the fastest buzz should win, got %+v", selected.Game)
+	}
+	if q.Question == nil || len(q.Question.Choices) == 0 {
+		t.Errorf("QUESTION_RESPONSE without a question: %+v", q)
+	}
+}
+
+func buzz(gameId string, delay uint32) protocol.Buzz {
+	return protocol.Buzz{Gameplay: protocol.Gameplay{Request: protocol.RequestBuzz, GameId: gameId}, Delay: delay}
+}
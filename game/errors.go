@@ -0,0 +1,51 @@
+package game
+
+import (
+  "fmt"
+)
+
+// Stable, machine readable error codes. They are sent to the clients as
+// is, so don't rename them.
+type ErrorCode string
+const (
+  UnknownGame ErrorCode = "UNKNOWN_GAME"
+  GameFull ErrorCode = "GAME_FULL"
+  GameNotWaiting ErrorCode = "GAME_NOT_WAITING"
+  NotYourTurn ErrorCode = "NOT_YOUR_TURN"
+  NoQuestion ErrorCode = "NO_QUESTION"
+  NotInGame ErrorCode = "NOT_IN_GAME"
+  Internal ErrorCode = "INTERNAL"
+)
+
+// Error is returned by the game service for everything that is not a
+// plain bug, the code says what went wrong.
+type Error struct {
+  Code ErrorCode
+  Msg string
+}
+
+func (e *Error) Error() string {
+  return e.Msg
+}
+
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+  return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Is the player allowed to make the move?
+func (g *Game) CheckTurn(playerId string) error {
+  if g.CurrentPlayerId != playerId {
+    return NewError(NotYourTurn, "Not your turn, waiting on %q", g.CurrentPlayerId)
+  }
+
+  return nil
+}
+
+// Does the player have a seat in the game?
+func (g *Game) CheckSeat(playerId string) error {
+  if g.GetPlayerByUuid(playerId) == nil {
+    return NewError(NotInGame, "Not a player in game %q", g.GameId)
+  }
+
+  return nil
+}
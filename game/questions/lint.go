@@ -0,0 +1,130 @@
+package questions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Every question is asked with the correct answer and this many wrong ones.
+const incorrectPerQuestion = 3
+
+// A category needs a question for each point value, 10 to 50.
+const questionsPerCategory = 5
+
+// Problem is something wrong with a question file.
+type Problem struct {
+	File     string `json:"file"`
+	Category string `json:"category,omitempty"`
+	// 1 based, 0 when the problem is not with a single question
+	Question int    `json:"question,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Lint checks the question files in dir, the files PopulateCategories
+// reads. Categories with problems may be skipped or break a game.
+func Lint(dir string) ([]Problem, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []Problem{}
+	seen := map[string]string{} // category to the file it was first found in
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		name := filepath.Join(dir, f.Name())
+
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		// unknown fields are likely typos, the loader would silently drop them
+		list := QuestionsList{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&list); err != nil {
+			problems = append(problems, Problem{File: name, Message: err.Error()})
+			continue
+		}
+
+		if len(list.Categories) == 0 {
+			problems = append(problems, Problem{File: name, Message: "no categories"})
+		}
+
+		for _, cat := range list.Categories {
+			problems = append(problems, lintCategory(name, cat, seen)...)
+		}
+	}
+
+	return problems, nil
+}
+
+func lintCategory(file string, cat *Category, seen map[string]string) []Problem {
+	problems := []Problem{}
+	add := func(question int, format string, args ...interface{}) {
+		problems = append(problems, Problem{
+			File:     file,
+			Category: cat.CategoryName,
+			Question: question,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if strings.TrimSpace(cat.CategoryName) == "" {
+		add(0, "category has no name")
+	} else if first, ok := seen[cat.CategoryName]; ok {
+		add(0, "category also in %s, only one is used", first)
+	} else {
+		seen[cat.CategoryName] = file
+	}
+
+	if len(cat.Questions) < questionsPerCategory {
+		add(0, "%d questions, needs at least %d or the category is skipped", len(cat.Questions), questionsPerCategory)
+	}
+
+	texts := map[string]int{}
+	for i, q := range cat.Questions {
+		n := i + 1
+		if q == nil {
+			add(n, "null question")
+			continue
+		}
+
+		if strings.TrimSpace(q.QuestionText) == "" {
+			add(n, "no question text")
+		} else if first, ok := texts[q.QuestionText]; ok {
+			add(n, "same question as #%d", first)
+		} else {
+			texts[q.QuestionText] = n
+		}
+
+		if strings.TrimSpace(q.Correct) == "" {
+			add(n, "no correct answer")
+		}
+		if len(q.Incorrect) != incorrectPerQuestion {
+			add(n, "%d incorrect answers, needs %d", len(q.Incorrect), incorrectPerQuestion)
+		}
+
+		choices := map[string]bool{q.Correct: true}
+		for _, wrong := range q.Incorrect {
+			if strings.TrimSpace(wrong) == "" {
+				add(n, "empty incorrect answer")
+				continue
+			}
+			if choices[wrong] {
+				add(n, "answer %q appears twice", wrong)
+			}
+			choices[wrong] = true
+		}
+	}
+
+	return problems
+}
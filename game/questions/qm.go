@@ -35,6 +35,12 @@ var gameQuestions = cmap.New() // gameIds to their questions
 
 var questionDir = filepath.Join(".", "game", "questions", "questions")
 
+// SetQuestionDir sets where the category files are read from, before
+// they are loaded.
+func SetQuestionDir(dir string) {
+	questionDir = dir
+}
+
 // TODO: does this need to be a cmap (does this need to be threadsafe?)
 var categoryMap = map[string]string{} // map category to the file it is located in
 
@@ -193,3 +199,17 @@ func RemoveGameQuestion(gameId, category string, pointVal uint8) {
 
 
 
+
+// The questions of a game, by category, nil where already played.
+func GameQuestions(gameId string) map[string][]*Question {
+	if tmp, ok := gameQuestions.Get(gameId); ok {
+		return tmp.(map[string][]*Question)
+	}
+
+	return nil
+}
+
+// Puts back the questions of a game, when it is restored.
+func SetGameQuestions(gameId string, qs map[string][]*Question) {
+	gameQuestions.Set(gameId, qs)
+}
@@ -0,0 +1,93 @@
+package game
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "gogo-sockets/game/questions"
+)
+
+// Everything needed to pick a game back up after a restart, including
+// what is not sent to the clients.
+type gameSnapshot struct {
+  Game *Game `json:"game"`
+  Question *QuestionState `json:"question,omitempty"`
+  Questions map[string][]*questions.Question `json:"questions"`
+}
+
+// Writes every game in progress to the file, so they survive a restart.
+// Returns the number of games written.
+func SaveSnapshot(path string) (int, error) {
+  gls, err := AllGames()
+  if err != nil {
+    return 0, err
+  }
+
+  snaps := make([]gameSnapshot, 0, len(gls))
+  for _, g := range gls {
+    snap := gameSnapshot{
+      Game: g,
+      Question: g.QuestionState(),
+      Questions: questions.GameQuestions(g.GameId),
+    }
+    snaps = append(snaps, snap)
+  }
+
+  data, err := json.Marshal(snaps)
+  if err != nil {
+    return 0, err
+  }
+
+  // don't leave half a snapshot behind
+  tmp := path + ".tmp"
+  if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+    return 0, err
+  }
+
+  return len(snaps), os.Rename(tmp, path)
+}
+
+// Restores the games written by SaveSnapshot and removes the file, so they
+// are only restored once. Every player starts out disconnected. A missing
+// file is not an error.
+func LoadSnapshot(path string) ([]*Game, error) {
+  data, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  snaps := []gameSnapshot{}
+  if err := json.Unmarshal(data, &snaps); err != nil {
+    return nil, err
+  }
+
+  gls := make([]*Game, 0, len(snaps))
+  for _, snap := range snaps {
+    g := snap.Game
+    if g == nil {
+      continue
+    }
+
+    for _, p := range g.Players {
+      p.Disconnected = true
+    }
+
+    if qs := snap.Question; qs != nil {
+      q := qs.Question
+      q.correctIndex = qs.CorrectIndex
+      for _, b := range qs.Buzzes {
+        q.buzzes = append(q.buzzes, &Buzz{b.PlayerId, b.Delay, b.Expired})
+      }
+      g.currentQuestion = &q
+    }
+
+    questions.SetGameQuestions(g.GameId, snap.Questions)
+    gMap.Set(g.GameId, g)
+    gls = append(gls, g)
+  }
+
+  return gls, os.Remove(path)
+}
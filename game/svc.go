@@ -1,7 +1,6 @@
 package game
 
 import (
-  "errors"
   "fmt"
   "gogo-sockets/game/questions"
   "github.com/google/uuid"
@@ -25,7 +24,7 @@ func AllGames() ([]*Game, error) {
     }
 
     // found a non-game value, ack!
-    return nil, errors.New("Found a non-game value in the games database")
+    return nil, NewError(Internal, "Found a non-game value in the games database")
   }
 
   return gls, nil;
@@ -70,32 +69,56 @@ func SetGameState(gameId string, state GameState) *Game {
 // a player just disconnected, we need to remove them from the game
 func RemovePlayer(playerId string) (*Game, bool) {
 	
-	itms := gMap.Items()
+	g, ok := FindPlayerGame(playerId)
+	if !ok {
+		return nil, false
+	}
 
-	for _, v  := range itms {
-      if g, ok := v.(*Game); ok {
-	    for i := 0; i < len(g.Players); i++ {
-	      if g.Players[i].PlayerId == playerId {
+	for i := 0; i < len(g.Players); i++ {
+		if g.Players[i].PlayerId == playerId {
 			newPlayers := g.Players[:i]
 			newPlayers = append(newPlayers, g.Players[i+1:]...)
 			g.Players = newPlayers
 			break
-	      }
-	    }
-	  
-	    if len(g.Players) == 0 {
-	      // delete game
-		  return g, true
-	    } else {
-	      // for now just do the same thing
-		  // might change this later
-	      return g, false
-	    }
-	  }
+		}
+	}
+
+	if len(g.Players) == 0 {
+		// delete game
+		return g, true
+	}
+
+	// for now just do the same thing
+	// might change this later
+	return g, false
+}
+
+// Finds the game the player has a seat in.
+func FindPlayerGame(playerId string) (*Game, bool) {
+  for _, v := range gMap.Items() {
+    g, ok := v.(*Game)
+    if !ok {
+      continue
     }
-  
-    return nil, false
-  
+
+    if g.GetPlayerByUuid(playerId) != nil {
+      return g, true
+    }
+  }
+
+  return nil, false
+}
+
+// Marks the player's seat as connected or not, the seat is kept either
+// way. Returns the game the player is in, nil if they are not in one.
+func SetPlayerConnected(playerId string, connected bool) *Game {
+  g, ok := FindPlayerGame(playerId)
+  if !ok {
+    return nil
+  }
+
+  g.GetPlayerByUuid(playerId).Disconnected = !connected
+  return g
 }
 
 func RemoveGame(gameId string) {
@@ -143,7 +166,7 @@ func CreateGame(host, hostname string, numCategories, questionsPerCategory, tota
 func JoinGame(gameId, playerId, playerName string) (*Game, error) {
   g, ok := GetGame(gameId)
   if !ok {
-    return nil, fmt.Errorf("In Join, Unknown game: %q", gameId)
+    return nil, NewError(UnknownGame, "In Join, Unknown game: %q", gameId)
   }
 
   // define the new player
@@ -155,11 +178,11 @@ func JoinGame(gameId, playerId, playerName string) (*Game, error) {
   }
 
   if g.State != WAITING {
-    return nil, errors.New("Game not waiting for players")
+    return nil, NewError(GameNotWaiting, "Game not waiting for players")
   }
 
   if len(g.Players) > 2 {
-    return nil, errors.New("Game already has more than 2 players")
+    return nil, NewError(GameFull, "Game already has more than 2 players")
   }
 
   g.Players = append(g.Players, newPlayer)
@@ -178,7 +201,7 @@ func JoinGame(gameId, playerId, playerName string) (*Game, error) {
 func LeaveGame(gameId, player string) (*Game, error) {
   g, ok := GetGame(gameId)
   if !ok {
-    return nil, fmt.Errorf("In Leave, Unknown game: %q", gameId)
+    return nil, NewError(UnknownGame, "In Leave, Unknown game: %q", gameId)
   }
 
 
@@ -212,7 +235,7 @@ func UpdateQuestionCount(gameId string, qcount uint8) (*Game, error) {
 
   g, ok := GetGame(gameId)
   if !ok {
-    return nil, fmt.Errorf("In UpdateQuestionCount, Unknown game: %q", gameId)
+    return nil, NewError(UnknownGame, "In UpdateQuestionCount, Unknown game: %q", gameId)
   }
 
   g.State = SPIN
@@ -227,7 +250,7 @@ func UpdateQuestionCount(gameId string, qcount uint8) (*Game, error) {
 func QuestionSelect(gameId, category string, pointValue uint8) (Question, error) {
 	g, ok := GetGame(gameId)
 	if !ok {
-		return Question{}, fmt.Errorf("In QuestionSelect, Unknown game: %q", gameId)
+		return Question{}, NewError(UnknownGame, "In QuestionSelect, Unknown game: %q", gameId)
 	}
 	
 	qInternal := questions.GetGameQuestion(gameId, category, pointValue)
@@ -322,6 +345,9 @@ func SetNewCurrentPlayer(g *Game) (bool, *Game, error) {
     }
 
     for _, b := range existingGame.currentQuestion.buzzes {
+      if b.expired {
+        continue
+      }
       if b.delay < bestTime  {
         bestTime = b.delay
         winner = b.playerId
@@ -350,12 +376,20 @@ func SetNewCurrentPlayer(g *Game) (bool, *Game, error) {
 func IncomingAnswer(gameId, clientId string, answerIndex uint8) (bool, int, *Game, error) {
 	g, ok := GetGame(gameId)
 	if !ok {
-		return false, -1, &Game{}, fmt.Errorf("In IncomingAnswer, Unknown game: %q", gameId)
+		return false, -1, &Game{}, NewError(UnknownGame, "In IncomingAnswer, Unknown game: %q", gameId)
+	}
+
+	if g.currentQuestion == nil {
+		return false, -1, g, NewError(NoQuestion, "In IncomingAnswer, no question is being played")
 	}
 
 	correct := false
 	player := g.GetPlayerByUuid(clientId)
 	if player != nil {
+		if err := g.CheckTurn(clientId); err != nil {
+			return false, -1, g, err
+		}
+
 		correct = g.currentQuestion.correctIndex == answerIndex
 		player.updateScore(g.currentQuestion.PointValue, correct)
 	} // else (actually not an error)
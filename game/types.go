@@ -1,5 +1,6 @@
 package game
 
+// Where a game is at, sent as its number.
 type GameState int
 const (
   WAITING GameState = iota
@@ -10,15 +11,43 @@ const (
   QUESTION
 )
 
+var stateNames = map[GameState]string{
+  WAITING: "waiting",
+  UNKNOWN: "unknown",
+  STARTED: "started",
+  ENDED: "ended",
+  SPIN: "spin",
+  QUESTION: "question",
+}
+
+func (s GameState) String() string {
+  if name, ok := stateNames[s]; ok {
+    return name
+  }
+  return "unknown"
+}
+
+// Every game state, in order.
+func GameStates() []GameState {
+  states := make([]GameState, 0, len(stateNames))
+  for s := WAITING; int(s) < len(stateNames); s++ {
+    states = append(states, s)
+  }
+  return states
+}
+
+// A seat in a game, as every client sees it.
 type Player struct {
   PlayerId string `json:"playerId"`
   
   // TODO: send me the name
   Name string `json:"name"`
   
-  // highest/lowest score a player can have if 6 categories is 6*(10*(5+4+...+1)) = +/-900 -> int16
+  // wrong answers cost their points, so it can go negative: with 6 categories it
+  // is within 6*(10*(5+4+...+1)) = +/-900 -> int16
   Score int16 `json:"score"` 
   CurrentPlayer bool // is this player is the current player?
+  Disconnected bool `json:"disconnected"` // dropped, seat held until they come back or time out
   //host bool		// is this the host player? doesn't export to json
 }
 
@@ -36,6 +65,7 @@ type Buzz struct {
   expired bool // did the player actually buzz or did time expire?
 }
 
+// A question as the players see it, without the answer.
 type Question struct {
   Category string `json:"category"`
   PointValue uint8 `json:"pointValue"`
@@ -45,6 +75,7 @@ type Question struct {
   buzzes []*Buzz
 }
 
+// A game, as every client sees it.
 type Game struct {
   GameId string `json:"gameId"`
   State GameState `json:"gameState"`
@@ -79,3 +110,37 @@ func (g *Game) SetCurrentPlayer(playerId string) {
 	}
   }
 }
+
+// The question being played, nil between questions.
+func (g *Game) CurrentQuestion() *Question {
+  return g.currentQuestion
+}
+
+// A question with what the players are not told: the answer and the
+// buzzes so far.
+type QuestionState struct {
+  Question
+  CorrectIndex uint8 `json:"correctIndex"`
+  Buzzes []BuzzState `json:"buzzes"`
+}
+
+type BuzzState struct {
+  PlayerId string `json:"playerId"`
+  Delay uint32 `json:"delay"`
+  Expired bool `json:"expired"`
+}
+
+// The full state of the question being played, nil between questions.
+func (g *Game) QuestionState() *QuestionState {
+  q := g.currentQuestion
+  if q == nil {
+    return nil
+  }
+
+  qs := &QuestionState{Question: *q, CorrectIndex: q.correctIndex, Buzzes: []BuzzState{}}
+  for _, b := range q.buzzes {
+    qs.Buzzes = append(qs.Buzzes, BuzzState{b.playerId, b.delay, b.expired})
+  }
+
+  return qs
+}
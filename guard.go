@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errTooManyConns = errors.New("Too many connections")
+	errBanned       = errors.New("Too many failed logins, try again later")
+)
+
+// connGuard decides who may open a socket, before the upgrade: allowed
+// origins, connection limits per remote IP and overall, and temporary bans
+// for IPs that keep failing the HELO check.
+type connGuard struct {
+	// Allowed Origin headers, "*" allows any. Empty only allows the same
+	// origin as the request.
+	origins map[string]bool
+
+	// Most concurrent connections per IP and overall, 0 is no limit.
+	perIP  int
+	global int
+
+	// Failed HELOs within banFor that get an IP banned for banFor, 0
+	// never bans.
+	banAfter int
+	banFor   time.Duration
+
+	mu       sync.Mutex
+	conns    map[string]int
+	total    int
+	failures map[string][]time.Time
+	bans     map[string]time.Time
+
+	// When failures and bans were last swept for expired entries, see
+	// prune.
+	swept time.Time
+}
+
+func newConnGuard(cfg *Config) *connGuard {
+	g := &connGuard{
+		origins:  make(map[string]bool),
+		perIP:    cfg.MaxConnsPerIP,
+		global:   cfg.MaxConns,
+		banAfter: cfg.BanAfter,
+		banFor:   cfg.BanFor,
+		conns:    make(map[string]int),
+		failures: make(map[string][]time.Time),
+		bans:     make(map[string]time.Time),
+	}
+	for _, o := range strings.Split(cfg.AllowedOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			g.origins[strings.ToLower(o)] = true
+		}
+	}
+
+	return g
+}
+
+// checkOrigin is the upgrader's CheckOrigin.
+func (g *connGuard) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser
+		return true
+	}
+
+	if len(g.origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	return g.origins["*"] || g.origins[strings.ToLower(origin)]
+}
+
+// admit counts a new connection from the IP, or refuses it. Every admitted
+// connection must be released.
+func (g *connGuard) admit(ip string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+
+	if until, ok := g.bans[ip]; ok {
+		if now.Before(until) {
+			return errBanned
+		}
+		delete(g.bans, ip)
+	}
+
+	if (g.global > 0 && g.total >= g.global) || (g.perIP > 0 && g.conns[ip] >= g.perIP) {
+		return errTooManyConns
+	}
+
+	g.conns[ip]++
+	g.total++
+	return nil
+}
+
+// release forgets a connection from the IP.
+func (g *connGuard) release(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.total--
+	if g.conns[ip]--; g.conns[ip] <= 0 {
+		delete(g.conns, ip)
+	}
+}
+
+// fail records a failed HELO check from the IP, banning it once there
+// are too many.
+func (g *connGuard) fail(ip string) {
+	if g.banAfter <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	recent := g.failures[ip][:0]
+	for _, t := range g.failures[ip] {
+		if now.Sub(t) < g.banFor {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) >= g.banAfter {
+		g.bans[ip] = now.Add(g.banFor)
+		delete(g.failures, ip)
+		return
+	}
+	g.failures[ip] = recent
+}
+
+// prune forgets the failures and bans of IPs that have not failed within
+// banFor, so IPs that fail once and never come back are not kept. It
+// sweeps at most once every banFor. Called with mu held.
+func (g *connGuard) prune(now time.Time) {
+	if now.Sub(g.swept) < g.banFor {
+		return
+	}
+	g.swept = now
+
+	for ip, failures := range g.failures {
+		// oldest first, the last failure is the newest
+		if len(failures) == 0 || now.Sub(failures[len(failures)-1]) >= g.banFor {
+			delete(g.failures, ip)
+		}
+	}
+	for ip, until := range g.bans {
+		if !now.Before(until) {
+			delete(g.bans, ip)
+		}
+	}
+}
+
+// remoteIP is the IP the request came from.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
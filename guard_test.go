@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnGuardBans(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.BanAfter, cfg.BanFor = 2, 50*time.Millisecond
+	g := newConnGuard(cfg)
+
+	g.fail("1.2.3.4")
+	if err := g.admit("1.2.3.4"); err != nil {
+		t.Fatalf("admitted after one failure: %v", err)
+	}
+	g.release("1.2.3.4")
+
+	g.fail("1.2.3.4")
+	if err := g.admit("1.2.3.4"); err != errBanned {
+		t.Fatalf("got %v after too many failures, want errBanned", err)
+	}
+
+	time.Sleep(cfg.BanFor)
+	if err := g.admit("1.2.3.4"); err != nil {
+		t.Fatalf("still refused once the ban ran out: %v", err)
+	}
+	g.release("1.2.3.4")
+}
+
+func TestConnGuardPrunesFailures(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.BanAfter, cfg.BanFor = 5, 50*time.Millisecond
+	g := newConnGuard(cfg)
+
+	// IPs that fail once and never come back
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		g.fail(ip)
+	}
+
+	time.Sleep(cfg.BanFor)
+	if err := g.admit("10.0.0.9"); err != nil {
+		t.Fatal(err)
+	}
+	g.release("10.0.0.9")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.failures) != 0 {
+		t.Errorf("failures kept past their window: %v", g.failures)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"strconv"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// Used by HandleMessage, set up in main.
+var messages *Router
+
+// newMessageRouter registers the handler of every message the clients
+// send. The readPump has already checked their rate limits.
+func newMessageRouter() *Router {
+	rt := newRouter()
+	rt.Use(recoverPanics, logRequests, countRequests)
+
+	rt.Handle("INIT", handleInit)
+
+	rt.Split("GAME_REQ", field("action"))
+	rt.Handle("GAME_REQ/CREATE", typed(handleCreate), idempotent)
+	rt.Handle("GAME_REQ/JOIN", typed(handleJoin), idempotent)
+	rt.Handle("GAME_REQ/LEAVE", typed(handleLeave))
+	rt.Handle("GAME_REQ/SPECTATE", typed(handleSpectate), capable(protocol.CapSpectate), inGame)
+	rt.Handle("GAME_REQ/UNSPECTATE", typed(handleUnspectate), capable(protocol.CapSpectate))
+
+	rt.Handle("BEGIN_GAME", typed(handleBeginGame), inGame, seated)
+	rt.Handle("NEXT_ROUND", typed(handleNextRound), inGame, seated)
+
+	rt.Split("GAMEPLAY", field("request"))
+	rt.Handle("GAMEPLAY/WHEEL_SPIN", typed(handleWheelSpin), inGame, seated, onTurn)
+	rt.Handle("GAMEPLAY/QUESTION_SELECT", typed(handleQuestionSelect), inGame, seated, onTurn)
+	rt.Handle("GAMEPLAY/BUZZ", typed(handleBuzz), idempotent, inGame, seated)
+	rt.Handle("GAMEPLAY/ANSWER", typed(handleAnswer), idempotent, inGame, seated)
+
+	return rt
+}
+
+// Handles the message, including sending an error if required
+func HandleMessage(client *Client, msg []byte) {
+	f, err := protocol.Decode(msg)
+	if err != nil {
+		SendError(client, "", err)
+		return
+	}
+	messages.Dispatch(client, f)
+}
+
+func handleInit(r *Request) error {
+	// new clients start out in the lobby
+	r.Sender.Join(r.Client.Id(), lobbyRoom)
+
+	gls, err := game.AllGames()
+	if err != nil {
+		return err
+	}
+	return r.Reply("GAMES", gls)
+}
+
+func handleCreate(r *Request, req *protocol.GameRequest) error {
+	clientId := r.Client.Id()
+
+	// this player will be the host
+	g := game.CreateGame(clientId, req.Name, req.NumCategories, req.QuestionsPerCategory, req.TotalQuestions)
+	r.Sender.Leave(clientId, lobbyRoom)
+	r.Sender.Join(clientId, gameRoom(g.GameId))
+
+	if err := r.Reply("START_WAIT", g); err != nil {
+		return err
+	}
+	return broadcastGames(r.Sender)
+}
+
+func handleJoin(r *Request, req *protocol.GameRequest) error {
+	clientId := r.Client.Id()
+
+	g, err := game.JoinGame(req.GameId, clientId, req.Name)
+	if err != nil {
+		return err
+	}
+	r.Sender.Leave(clientId, lobbyRoom)
+	r.Sender.Join(clientId, gameRoom(g.GameId))
+
+	// the third player starts the game
+	header := "START_WAIT"
+	if len(g.Players) == 3 {
+		header = "START_ROUND"
+	}
+	if err := MarshalAndSendToGame(r.Sender, g, header, g); err != nil {
+		return err
+	}
+	return broadcastGames(r.Sender)
+}
+
+func handleLeave(r *Request, req *protocol.GameRequest) error {
+	clientId := r.Client.Id()
+
+	g, err := game.LeaveGame(req.GameId, clientId)
+	if err != nil {
+		return err
+	}
+	r.Sender.Leave(clientId, gameRoom(req.GameId))
+	r.Sender.Join(clientId, lobbyRoom)
+
+	if g != nil { // there are others waiting
+		if err := MarshalAndSendToGame(r.Sender, g, "START_WAIT", g); err != nil {
+			return err
+		}
+	} else { // last one out, spectators go back to the lobby
+		closeGameRooms(r.Sender, req.GameId)
+	}
+	return broadcastGames(r.Sender)
+}
+
+// handleSpectate lets the client watch a game without taking a seat.
+func handleSpectate(r *Request, req *protocol.GameRequest) error {
+	r.Sender.Leave(r.Client.Id(), lobbyRoom)
+	r.Sender.Join(r.Client.Id(), spectatorRoom(r.Game.GameId))
+
+	return r.Reply("SPECTATING", r.Game)
+}
+
+func handleUnspectate(r *Request, req *protocol.GameRequest) error {
+	r.Sender.Leave(r.Client.Id(), spectatorRoom(req.GameId))
+	r.Sender.Join(r.Client.Id(), lobbyRoom)
+
+	gls, err := game.AllGames()
+	if err != nil {
+		return err
+	}
+	return r.Reply("GAMES", gls)
+}
+
+// handleBeginGame sets the question count and starts the first round.
+func handleBeginGame(r *Request, body *protocol.BeginGame) error {
+	g, err := game.UpdateQuestionCount(r.Game.GameId, body.QuestionCount)
+	if err != nil {
+		return err
+	}
+
+	if err := MarshalAndSendToGame(r.Sender, g, "START_ROUND", g); err != nil {
+		return err
+	}
+	return broadcastGames(r.Sender)
+}
+
+func handleNextRound(r *Request, body *protocol.NextRound) error {
+	g := game.SetGameState(r.Game.GameId, game.SPIN)
+
+	if err := MarshalAndSendToGame(r.Sender, g, "START_ROUND", g); err != nil {
+		return err
+	}
+	return broadcastGames(r.Sender)
+}
+
+// handleWheelSpin forwards the spin to the other clients, the game
+// package has nothing to do with it.
+func handleWheelSpin(r *Request, req *protocol.WheelSpin) error {
+	return MarshalAndSendToGame(r.Sender, r.Game, "WHEEL_SPUN", protocol.WheelSpun{PlayerId: r.Client.Id(), SpinFactor: req.SpinFactor})
+}
+
+// handleQuestionSelect sends the chosen question to everyone in the game.
+func handleQuestionSelect(r *Request, req *protocol.QuestionSelect) error {
+	q, err := game.QuestionSelect(r.Game.GameId, req.Category, req.PointValue)
+	if err != nil {
+		return err
+	}
+
+	g := game.SetGameState(r.Game.GameId, game.QUESTION)
+	return MarshalAndSendToGame(r.Sender, g, "QUESTION_RESPONSE", protocol.QuestionResponse{Question: &q, Game: g})
+}
+
+// handleBuzz registers the buzz. We expect every player to buzz, if time
+// runs out their delay is protocol.BuzzExpired. The third buzz picks the
+// player who answers, or ends the question if nobody buzzed in time.
+func handleBuzz(r *Request, req *protocol.Buzz) error {
+	clientId, g := r.Client.Id(), r.Game
+	if g.CurrentQuestion() == nil {
+		return game.NewError(game.NoQuestion, "No question is being played")
+	}
+
+	expiredBuzz := req.Delay == protocol.BuzzExpired
+	if r.Client.ProtocolVersion() >= protocol.Version2 {
+		// newer clients flag it, the delay is how long they waited
+		expiredBuzz = expiredBuzz || req.Expired
+	}
+	if !expiredBuzz {
+		// delays are in milliseconds
+		buzzLatency.observe(float64(req.Delay) / 1000)
+	}
+
+	// an expired buzz never wins, whatever the client waited
+	delay := req.Delay
+	if expiredBuzz {
+		delay = protocol.BuzzExpired
+	}
+
+	if !game.RegisterBuzz(g.GameId, clientId, delay, expiredBuzz) {
+		// wait for the other buzzes, only tell them about real ones
+		if expiredBuzz {
+			return nil
+		}
+		return MarshalAndSendToGame(r.Sender, g, "BUZZED", protocol.Buzzed{PlayerId: clientId, Delay: req.Delay})
+	}
+
+	expired, g, err := game.SetNewCurrentPlayer(g)
+	if err != nil {
+		return err
+	}
+
+	if !expired {
+		return MarshalAndSendToGame(r.Sender, g, "PLAYER_SELECTED", protocol.PlayerSelected{Game: g})
+	}
+
+	// nobody buzzed in time, cancel the question with no player
+	correct, correctAnswer, ga, err := game.IncomingAnswer(g.GameId, "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", 0)
+	if err != nil {
+		return err
+	}
+	return sendAnswer(r.Sender, g, protocol.AnswerResponse{Correct: correct, CorrectAnswer: correctAnswer, Game: ga})
+}
+
+func handleAnswer(r *Request, req *protocol.Answer) error {
+	correct, correctAnswer, g, err := game.IncomingAnswer(r.Game.GameId, r.Client.Id(), req.AnswerIndex)
+	if err != nil {
+		return err
+	}
+	questionsAnswered.inc(strconv.FormatBool(correct))
+
+	return sendAnswer(r.Sender, g, protocol.AnswerResponse{Correct: correct, CorrectAnswer: correctAnswer, Game: g})
+}
+
+// sendAnswer sends the answer to everyone in the game, and drops the game
+// if that was its last question.
+func sendAnswer(hub Sender, g *game.Game, resp protocol.AnswerResponse) error {
+	if err := MarshalAndSendToGame(hub, g, "ANSWER_RESPONSE", resp); err != nil {
+		return err
+	}
+
+	if g.State == game.ENDED {
+		return removeGame(hub, g.GameId)
+	}
+	return nil
+}
+
+// broadcastGames sends the lobby the game list.
+func broadcastGames(hub Sender) error {
+	gls, err := game.AllGames()
+	if err != nil {
+		return err
+	}
+	return MarshalAndSendToRoom(hub, lobbyRoom, "GAMES", gls)
+}
+
+// removeGame drops the game, sends whoever was in its rooms back to the
+// lobby and sends the lobby the new game list.
+func removeGame(hub Sender, gameId string) error {
+	game.RemoveGame(gameId)
+	closeGameRooms(hub, gameId)
+
+	return broadcastGames(hub)
+}
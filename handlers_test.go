@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// fakeSender records what the handlers do with the rooms.
+type fakeSender struct {
+	events []string
+}
+
+func (s *fakeSender) Join(clientId, room string) {
+	s.events = append(s.events, "join "+clientId+" "+room)
+}
+
+func (s *fakeSender) Leave(clientId, room string) {
+	s.events = append(s.events, "leave "+clientId+" "+room)
+}
+
+func (s *fakeSender) Publish(room string, message []byte) {
+	s.events = append(s.events, "publish "+room+" "+frameHeader(message))
+}
+
+func (s *fakeSender) Dissolve(room string) {
+	s.events = append(s.events, "dissolve "+room)
+}
+
+// fakePeer records the replies it is sent.
+type fakePeer struct {
+	id      string
+	version int
+	caps    map[string]bool
+	replies []string
+}
+
+func (p *fakePeer) Id() string                 { return p.id }
+func (p *fakePeer) ProtocolVersion() int       { return p.version }
+func (p *fakePeer) Can(capability string) bool { return p.caps[capability] }
+func (p *fakePeer) Reply(requestId, header string, body interface{}) error {
+	p.replies = append(p.replies, header)
+	return nil
+}
+
+func newFakePeer(name string) *fakePeer {
+	return &fakePeer{id: fmt.Sprintf("%s-%d", name, time.Now().UnixNano()), version: protocol.Version, caps: map[string]bool{}}
+}
+
+func TestHandleCreate(t *testing.T) {
+	testSetup(t)
+
+	p, s := newFakePeer("host"), &fakeSender{}
+	r := &Request{Client: p, Sender: s}
+	err := handleCreate(r, &protocol.GameRequest{Action: protocol.ActionCreate, Name: "Host", NumCategories: 3, QuestionsPerCategory: 5, TotalQuestions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, _ := game.FindPlayerGame(p.id)
+	if g == nil {
+		t.Fatal("The host has no game")
+	}
+
+	want := []string{
+		"leave " + p.id + " " + lobbyRoom,
+		"join " + p.id + " " + gameRoom(g.GameId),
+		"publish " + lobbyRoom + " GAMES",
+	}
+	if fmt.Sprint(s.events) != fmt.Sprint(want) {
+		t.Errorf("rooms: got %v, want %v", s.events, want)
+	}
+	if fmt.Sprint(p.replies) != "[START_WAIT]" {
+		t.Errorf("replies: got %v, want [START_WAIT]", p.replies)
+	}
+}
+
+func TestHandleBuzzWithoutQuestion(t *testing.T) {
+	testSetup(t)
+
+	peers := []*fakePeer{newFakePeer("a"), newFakePeer("b"), newFakePeer("c")}
+	g := game.CreateGame(peers[0].id, "A", 3, 5, 2)
+	for _, p := range peers[1:] {
+		if _, err := game.JoinGame(g.GameId, p.id, p.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &fakeSender{}
+	r := &Request{Client: peers[1], Sender: s, Game: g}
+	err := handleBuzz(r, &protocol.Buzz{Gameplay: protocol.Gameplay{Request: protocol.RequestBuzz, GameId: g.GameId}, Delay: 100})
+	if code := errorCode(err); code != string(game.NoQuestion) {
+		t.Errorf("got %v (%s), want %s", err, code, game.NoQuestion)
+	}
+	if len(s.events) > 0 {
+		t.Errorf("a refused buzz sent %v", s.events)
+	}
+}
+
+func TestCapable(t *testing.T) {
+	handled := false
+	h := capable(protocol.CapSpectate)(func(r *Request) error {
+		handled = true
+		return nil
+	})
+
+	p := newFakePeer("watcher")
+	err := h(&Request{Client: p, Sender: &fakeSender{}, Route: "GAME_REQ/SPECTATE"})
+	if handled || errorCode(err) != errUnknownRequest {
+		t.Errorf("without the capability: handled %v, error %v", handled, err)
+	}
+
+	p.caps[protocol.CapSpectate] = true
+	if err := h(&Request{Client: p, Sender: &fakeSender{}}); err != nil || !handled {
+		t.Errorf("with the capability: handled %v, error %v", handled, err)
+	}
+}
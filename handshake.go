@@ -0,0 +1,97 @@
+package main
+
+import (
+	"gogo-sockets/protocol"
+)
+
+// What this server supports, sent in WELCOME.
+var serverCapabilities = []string{
+	protocol.CapBatch,
+	protocol.CapResume,
+	protocol.CapRequestId,
+	protocol.CapSpectate,
+	protocol.CapSeq,
+}
+
+// negotiate settles the protocol version, capabilities and framing of the
+// client from its HELO: the newest version both sides speak, the
+// capabilities both support, and the framing it asked for if the server
+// has it. Clients that did not ask, version 1 clients among them, keep
+// the framing their HELO came in, detected.
+func negotiate(client *Client, h *protocol.Hello, detected protocol.Framing) {
+	client.framing = detected
+
+	client.Version = h.ProtocolVersion
+	if client.Version < protocol.MinVersion {
+		client.Version = protocol.Version1
+	}
+	if client.Version > protocol.Version {
+		client.Version = protocol.Version
+	}
+
+	client.caps = map[string]bool{}
+	if client.Version == protocol.Version1 {
+		// old clients never said what they support, they get what they
+		// always got
+		for _, c := range serverCapabilities {
+			client.caps[c] = c != protocol.CapSeq
+		}
+		return
+	}
+
+	// no framing named is not legacy here, it is whatever the HELO used
+	if f, ok := protocol.FramingFor(h.Framing); ok && h.Framing != "" {
+		client.framing = f
+	}
+
+	for _, c := range h.Capabilities {
+		for _, s := range serverCapabilities {
+			if c == s {
+				client.caps[c] = true
+			}
+		}
+	}
+}
+
+// Id is the clientId, for the handlers.
+func (c *Client) Id() string {
+	return c.ClientId
+}
+
+// ProtocolVersion is the version negotiated with the client.
+func (c *Client) ProtocolVersion() int {
+	return c.Version
+}
+
+// Can says if the client and the server both support the capability.
+func (c *Client) Can(capability string) bool {
+	return c.caps[capability]
+}
+
+// welcome answers the HELO of clients speaking version 2 or later. It is
+// written before the writePump starts, ahead of anything queued, and is
+// not numbered as it is never replayed.
+func welcome(client *Client) error {
+	if client.Version < protocol.Version2 {
+		return nil
+	}
+
+	msg, err := protocol.Encode(protocol.HeaderWelcome, protocol.Welcome{
+		ProtocolVersion: client.Version,
+		Capabilities:    serverCapabilities,
+		Framing:         client.framing.Name(),
+		ClientId:        client.ClientId,
+	})
+	if err != nil {
+		return err
+	}
+
+	// in the framing the client asked for
+	data, err := client.encode([][]byte{msg})
+	if err != nil {
+		return err
+	}
+
+	messagesOut.inc(protocol.HeaderWelcome)
+	return client.Session.Write(data, client.framing.Binary())
+}
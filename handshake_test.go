@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"gogo-sockets/protocol"
+)
+
+func TestNegotiateFraming(t *testing.T) {
+	legacy := protocol.DetectFraming([]byte("HELO"), false)
+	msgpack := protocol.DetectFraming(nil, true)
+
+	tests := []struct {
+		name     string
+		hello    protocol.Hello
+		detected protocol.Framing
+		want     string
+	}{
+		{name: "v1 legacy", hello: protocol.Hello{}, detected: legacy, want: protocol.FramingLegacy},
+		{name: "v1 binary", hello: protocol.Hello{}, detected: msgpack, want: protocol.FramingMsgpack},
+		{name: "v1 cannot ask", hello: protocol.Hello{Framing: protocol.FramingEnvelope}, detected: legacy, want: protocol.FramingLegacy},
+		{name: "v2 asked", hello: protocol.Hello{ProtocolVersion: protocol.Version2, Framing: protocol.FramingEnvelope}, detected: legacy, want: protocol.FramingEnvelope},
+		{name: "v2 binary without asking", hello: protocol.Hello{ProtocolVersion: protocol.Version2}, detected: msgpack, want: protocol.FramingMsgpack},
+		{name: "v2 asked for an unknown one", hello: protocol.Hello{ProtocolVersion: protocol.Version2, Framing: "xml"}, detected: msgpack, want: protocol.FramingMsgpack},
+	}
+
+	for _, tt := range tests {
+		client := &Client{}
+		negotiate(client, &tt.hello, tt.detected)
+		if got := client.framing.Name(); got != tt.want {
+			t.Errorf("%s: framing %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"gogo-sockets/game/questions"
+)
+
+// serveHealth is the liveness probe: the hub loop must answer within
+// -hub-probe-timeout. A wedged hub stalls every client, the process
+// should be restarted.
+func serveHealth(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if !hub.Alive(hub.cfg.HubProbeTimeout) {
+		http.Error(w, "hub not responding", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// serveReady is the readiness probe: the question categories are loaded,
+// the hub is alive and the server is not shutting down.
+func serveReady(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case atomic.LoadInt32(&draining) == 1:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case !questions.CategoriesInitialized:
+		http.Error(w, "question categories not loaded", http.StatusServiceUnavailable)
+	case !hub.Alive(hub.cfg.HubProbeTimeout):
+		http.Error(w, "hub not responding", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
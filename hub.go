@@ -4,50 +4,457 @@
 
 package main
 
-// Hub maintains the set of active clients and broadcasts messages to the
-// clients.
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The lobby is the room every client sits in while it is not part of a
+// game. Lobby traffic is the GAMES list.
+const lobbyRoom = "lobby"
+
+// gameRoom is the room holding the players of a game.
+func gameRoom(gameId string) string {
+	return "game:" + gameId
+}
+
+// spectatorRoom is the room holding the clients watching a game.
+func spectatorRoom(gameId string) string {
+	return "spectate:" + gameId
+}
+
+// DupPolicy says what the hub does when a client connects with the
+// ClientId of a client that is already connected.
+type DupPolicy int
+
+const (
+	// Refuse the new connection.
+	DupReject DupPolicy = iota
+
+	// Close the old connection, the new one takes over its rooms.
+	DupKick
+
+	// Keep both, every device of the client sits in the same rooms.
+	DupMulti
+)
+
+func ParseDupPolicy(s string) (DupPolicy, error) {
+	switch s {
+	case "reject":
+		return DupReject, nil
+	case "kick":
+		return DupKick, nil
+	case "multi":
+		return DupMulti, nil
+	}
+
+	return DupReject, fmt.Errorf("Unknown duplicate client policy %q", s)
+}
+
+// membership asks the hub to add or remove the connections of a client
+// from a room.
+type membership struct {
+	clientId string
+	room     string
+}
+
+// roomMessage is a message for every client in a room.
+type roomMessage struct {
+	room    string
+	message []byte
+}
+
+// Why the hub refuses to register a client.
+var (
+	errAlreadyConnected = errors.New("Already connected")
+	errShuttingDown     = errors.New("Server shutting down")
+)
+
+// registration asks the hub to register a client, refused tells why it
+// was not let in, nil if it was.
+type registration struct {
+	client  *Client
+	refused chan error
+}
+
+// dropRequest tells the hub a client's connection is gone, held tells
+// whether the client was detached rather than removed.
+type dropRequest struct {
+	client *Client
+	seated bool
+	held   chan bool
+}
+
+// kickRequest asks the hub to close every connection of a client, kicked
+// tells how many there were.
+type kickRequest struct {
+	clientId string
+	kicked   chan int
+}
+
+// lookupRequest asks the hub whether a ClientId is connected.
+type lookupRequest struct {
+	clientId  string
+	connected chan bool
+}
+
+// shutdownRequest asks the hub to send every client a last message and
+// close it, done is closed once they are all closed.
+type shutdownRequest struct {
+	message []byte
+	done    chan struct{}
+}
+
+// Sender is the part of the hub the handlers use: rooms, by clientId,
+// and the messages sent to them.
+type Sender interface {
+	Join(clientId, room string)
+	Leave(clientId, room string)
+	Publish(room string, message []byte)
+	Dissolve(room string)
+}
+
+var _ Sender = (*Hub)(nil)
+
+// Hub maintains the set of active clients and the rooms they sit in, and
+// publishes messages to the rooms.
 type Hub struct {
-	// Registered clients.
-	clients map[string]*Client
+	// Registered clients, all the connections of each ClientId.
+	clients map[string]map[*Client]bool
+
+	// Rooms by name, and the clients sitting in them.
+	rooms map[string]map[*Client]bool
+
+	// What to do with a second connection for a ClientId.
+	dupPolicy DupPolicy
 
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	// The settings, for the clients of the hub.
+	cfg *Config
+
+	// Who may connect and who gets in, and the message budgets of the
+	// clients, built from the settings.
+	guard  *connGuard
+	auth   Authenticator
+	limits *rateLimits
 
 	// Register requests from the clients.
-	register chan *Client
+	register chan registration
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Clients whose connection is gone.
+	drop chan dropRequest
+
+	// Room join requests from the clients.
+	join chan membership
+
+	// Room leave requests from the clients.
+	leave chan membership
+
+	// Messages for the clients of a single room.
+	publish chan roomMessage
+
+	// Rooms to close, their clients are moved back to the lobby.
+	dissolve chan string
+
+	// Clients to close on an admin's request.
+	kick chan kickRequest
+
+	// Lookups of the connected ClientIds.
+	lookup chan lookupRequest
+
+	// Server shutdown, closes every client.
+	shutdown chan shutdownRequest
+
+	// Liveness probes, taken by run to show it is not stuck.
+	probe chan struct{}
+
+	// Running writePumps, to wait for them to flush on shutdown.
+	pumps sync.WaitGroup
+
+	// The last message sent to the clients on shutdown. Set by run, once
+	// it is set no client is registered. Read by the clients it refused.
+	farewell     []byte
+	shuttingDown bool
 }
 
-func newHub() *Hub {
+// newHub makes a hub for the settings, which have been validated.
+func newHub(cfg *Config) (*Hub, error) {
+	dupPolicy, _ := ParseDupPolicy(cfg.DupPolicy)
+
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
+		register:   make(chan registration),
 		unregister: make(chan *Client),
-		clients:    make(map[string]*Client),
+		drop:       make(chan dropRequest),
+		join:       make(chan membership),
+		leave:      make(chan membership),
+		publish:    make(chan roomMessage),
+		dissolve:   make(chan string),
+		kick:       make(chan kickRequest),
+		lookup:     make(chan lookupRequest),
+		shutdown:   make(chan shutdownRequest),
+		probe:      make(chan struct{}),
+		clients:    make(map[string]map[*Client]bool),
+		rooms:      make(map[string]map[*Client]bool),
+		dupPolicy:  dupPolicy,
+		cfg:        cfg,
+		guard:      newConnGuard(cfg),
+		auth:       auth,
+		limits:     newRateLimits(cfg),
+	}, nil
+}
+
+// Register adds the client to the hub and counts its writePump, which
+// must be started. Returns errAlreadyConnected if the client was refused
+// because its ClientId is already connected, errShuttingDown once the
+// hub has shut down.
+func (h *Hub) Register(client *Client) error {
+	refused := make(chan error, 1)
+	h.register <- registration{client: client, refused: refused}
+	return <-refused
+}
+
+// Drop tells the hub the client's connection is gone. A seated client
+// that is not connected on another socket is detached: it keeps its rooms
+// until it is unregistered. Returns true if the client was detached.
+func (h *Hub) Drop(client *Client, seated bool) bool {
+	held := make(chan bool, 1)
+	h.drop <- dropRequest{client: client, seated: seated, held: held}
+	return <-held
+}
+
+// Join puts every connection of the client in the room, creating the room
+// if needed.
+func (h *Hub) Join(clientId, room string) {
+	h.join <- membership{clientId: clientId, room: room}
+}
+
+// Leave takes every connection of the client out of the room.
+func (h *Hub) Leave(clientId, room string) {
+	h.leave <- membership{clientId: clientId, room: room}
+}
+
+// Publish sends the message to every client in the room.
+func (h *Hub) Publish(room string, message []byte) {
+	h.publish <- roomMessage{room: room, message: message}
+}
+
+// Dissolve closes the room and moves its clients back to the lobby.
+func (h *Hub) Dissolve(room string) {
+	h.dissolve <- room
+}
+
+// Kick closes every connection of the client. Returns how many there were,
+// a detached client is not counted.
+func (h *Hub) Kick(clientId string) int {
+	kicked := make(chan int, 1)
+	h.kick <- kickRequest{clientId: clientId, kicked: kicked}
+	return <-kicked
+}
+
+// Connected tells whether the client is connected on any socket, a
+// detached client does not count.
+func (h *Hub) Connected(clientId string) bool {
+	connected := make(chan bool, 1)
+	h.lookup <- lookupRequest{clientId: clientId, connected: connected}
+	return <-connected
+}
+
+// Shutdown sends every client the message and closes it. The writePumps
+// flush what is queued before the close frame, Wait waits for them.
+func (h *Hub) Shutdown(message []byte) {
+	done := make(chan struct{})
+	h.shutdown <- shutdownRequest{message: message, done: done}
+	<-done
+}
+
+// Alive tells whether the run loop takes a probe within the timeout.
+func (h *Hub) Alive(timeout time.Duration) bool {
+	select {
+	case h.probe <- struct{}{}:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
 
+// Wait waits for every writePump to finish.
+func (h *Hub) Wait() {
+	h.pumps.Wait()
+}
+
 func (h *Hub) run() {
 	for {
 		select {
-		case client := <-h.register:
-			h.clients[client.ClientId] = client
+		case r := <-h.register:
+			r.refused <- h.add(r.client)
 		case client := <-h.unregister:
-			if _, ok := h.clients[client.ClientId]; ok {
-				delete(h.clients, client.ClientId)
-				close(client.Send)
-			}
-		case message := <-h.broadcast:
-			for clientId, client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, clientId)
+			h.remove(client)
+		case r := <-h.drop:
+			devices := h.clients[r.client.ClientId]
+			if !r.seated || !devices[r.client] || len(devices) > 1 {
+				// kicked, or still connected elsewhere, nothing to keep
+				h.remove(r.client)
+				r.held <- false
+				continue
+			}
+			delete(h.clients, r.client.ClientId)
+			atomic.AddInt64(&connectedClients, -1)
+			r.client.detach()
+			r.held <- true
+		case m := <-h.join:
+			members, ok := h.rooms[m.room]
+			if !ok {
+				members = make(map[*Client]bool)
+				h.rooms[m.room] = members
+			}
+			for client := range h.clients[m.clientId] {
+				members[client] = true
+			}
+		case m := <-h.leave:
+			for client := range h.clients[m.clientId] {
+				h.leaveRoom(client, m.room)
+			}
+		case m := <-h.publish:
+			for client := range h.rooms[m.room] {
+				if !client.queue(m.message) {
+					h.remove(client)
 				}
 			}
+		case room := <-h.dissolve:
+			members, ok := h.rooms[room]
+			if !ok || room == lobbyRoom {
+				continue
+			}
+			delete(h.rooms, room)
+			for client := range members {
+				if _, ok := h.rooms[lobbyRoom]; !ok {
+					h.rooms[lobbyRoom] = make(map[*Client]bool)
+				}
+				h.rooms[lobbyRoom][client] = true
+			}
+		case r := <-h.kick:
+			n := 0
+			for client := range h.clients[r.clientId] {
+				client.closeWith(closeKicked, "Kicked by an admin")
+				h.remove(client)
+				n++
+			}
+			r.kicked <- n
+		case r := <-h.lookup:
+			r.connected <- len(h.clients[r.clientId]) > 0
+		case r := <-h.shutdown:
+			h.shuttingDown, h.farewell = true, r.message
+			for _, devices := range h.clients {
+				for client := range devices {
+					if r.message != nil {
+						client.queue(r.message)
+					}
+					client.closeWith(websocket.CloseGoingAway, "Server shutting down")
+				}
+			}
+			close(r.done)
+		case <-h.probe:
+		}
+	}
+}
+
+// add registers the client, applying the duplicate policy if its
+// ClientId is already connected. Only called from run, so a client is
+// either counted in pumps before Shutdown or refused.
+func (h *Hub) add(client *Client) error {
+	if h.shuttingDown {
+		return errShuttingDown
+	}
+
+	devices := h.clients[client.ClientId]
+	if len(devices) > 0 {
+		switch h.dupPolicy {
+		case DupReject:
+			return errAlreadyConnected
+		case DupKick:
+			for old := range devices {
+				h.moveRooms(old, client)
+				old.closeWith(closeReplaced, "Replaced by a new connection")
+				h.remove(old)
+			}
+		case DupMulti:
+			for other := range devices {
+				h.copyRooms(other, client)
+				break
+			}
+		}
+	}
+
+	if h.clients[client.ClientId] == nil {
+		h.clients[client.ClientId] = make(map[*Client]bool)
+	}
+	h.clients[client.ClientId][client] = true
+	atomic.AddInt64(&connectedClients, 1)
+	h.pumps.Add(1)
+
+	if client.previous != nil {
+		// queue what it missed before any new room message
+		client.catchUp()
+		h.moveRooms(client.previous, client)
+	}
+	return nil
+}
+
+// copyRooms puts client in every room from sits in. Only called from run.
+func (h *Hub) copyRooms(from, client *Client) {
+	for _, members := range h.rooms {
+		if members[from] {
+			members[client] = true
+		}
+	}
+}
+
+// moveRooms moves client into the rooms of the one it replaces. Only
+// called from run, so no room message can slip in between.
+func (h *Hub) moveRooms(from, client *Client) {
+	h.copyRooms(from, client)
+	for room := range h.rooms {
+		h.leaveRoom(from, room)
+	}
+}
+
+// leaveRoom removes the client from a room, dropping the room once it is
+// empty. Only called from run.
+func (h *Hub) leaveRoom(client *Client, room string) {
+	members, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, client)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// remove forgets the client and closes its send channel. Only called from
+// run.
+func (h *Hub) remove(client *Client) {
+	for room := range h.rooms {
+		h.leaveRoom(client, room)
+	}
+	if devices := h.clients[client.ClientId]; devices[client] {
+		delete(devices, client)
+		atomic.AddInt64(&connectedClients, -1)
+		if len(devices) == 0 {
+			delete(h.clients, client.ClientId)
 		}
 	}
+	client.closeSend()
 }
@@ -5,39 +5,205 @@
 package main
 
 import (
+  "context"
   "fmt"
   "flag"
+  "crypto/tls"
   "log"
   "net/http"
+  "os"
+  "os/signal"
+  "sync/atomic"
+  "syscall"
+  "time"
+
+  "gogo-sockets/game"
+  "gogo-sockets/game/questions"
+  "gogo-sockets/protocol"
 )
 
 
-var addr = flag.String("addr", ":8080", "http service address")
+// the settings, see Config
+var configFile = flag.String("config", os.Getenv("GOGO_CONFIG"), "JSON file of settings, defaults to $GOGO_CONFIG")
+
+// mint a token instead of serving
+var signFor = flag.String("sign-token", "", "print a token for this clientId, signed with the token auth secret, and exit")
+var signTTL = flag.Duration("token-ttl", 24 * time.Hour, "how long a token from -sign-token is valid")
 
 func main() {
-  // get the addr
+  // the settings come from the defaults, the config file, the environment
+  // and the flags
+  cfg := defaultConfig()
+  cfg.bind(flag.CommandLine)
   flag.Parse()
+  if err := cfg.load(*configFile, flag.CommandLine); err != nil {
+    log.Fatal(err)
+  }
+
+  if *signFor != "" {
+    if cfg.AuthSecret == "" {
+      log.Fatal("-sign-token needs -auth-secret")
+    }
+    fmt.Println(signToken([]byte(cfg.AuthSecret), *signFor, time.Now().Add(*signTTL)))
+    return
+  }
 
   // the brodcaster, writer, reader extrodinair
   // thank you gorilla!!
-  hub := newHub()
+  hub, err := newHub(cfg)
+  if err != nil {
+    log.Fatal("Could not set up authentication: ", err)
+  }
+
+  upgrader.ReadBufferSize = cfg.ReadBufferSize
+  upgrader.WriteBufferSize = cfg.WriteBufferSize
+  upgrader.CheckOrigin = hub.guard.checkOrigin
+
+  // load the categories up front, readiness waits on them
+  questions.SetQuestionDir(cfg.QuestionDir)
+  questions.PopulateCategories()
+  if !questions.CategoriesInitialized {
+    log.Println("Could not load the question categories, not ready")
+  }
+
+  messages = newMessageRouter()
+
   // run in it's own goroutine
   go hub.run()
 
+  // pick up the games of the last run, players get the usual grace to
+  // come back
+  if cfg.SnapshotFile != "" {
+    gls, err := game.LoadSnapshot(cfg.SnapshotFile)
+    if err != nil {
+      log.Fatal("Could not restore games: ", err)
+    }
+    if len(gls) > 0 {
+      fmt.Println("Restored games: ", len(gls))
+      go releaseRestoredSeats(hub, gls)
+    }
+  }
+
   // basic handlers from golang
   // to be upgraded
-  http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+  http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
     serveWs(hub, w, r)
   })
 
+  // probes, for the orchestrator
+  http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+    serveHealth(hub, w, r)
+  })
+  http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+    serveReady(hub, w, r)
+  })
+  http.HandleFunc("/metrics", serveMetrics)
+
+  if cfg.Fallback {
+    http.Handle("/fallback/", &fallbackAPI{hub: hub})
+  }
+
+  if cfg.AdminToken != "" {
+    http.Handle("/admin/", &adminAPI{hub: hub, token: cfg.AdminToken})
+  } else {
+    fmt.Println("No -admin-token, admin API disabled")
+  }
+
   // http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
   //   fmt.Fprintf(w,"Listing on port %v", addr)
   // })
 
-  fmt.Println("Listenting on ", *addr)
-  err := http.ListenAndServe(*addr, nil)
+  server := &http.Server{Addr: cfg.Addr}
+
+  if cfg.TLSCert == "" || cfg.TLSKey == "" {
+    fmt.Println("Listenting on ", cfg.Addr)
+    go func() {
+      err := server.ListenAndServe()
+      if err != http.ErrServerClosed {
+        log.Fatal("ListenAndServe failed:", err)
+      }
+    }()
+    waitForShutdown(cfg, hub, server)
+    return
+  }
+
+  // the certificate is read again on SIGHUP or when it changes
+  certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey, cfg.CertPollInterval)
   if err != nil {
-    log.Fatal("ListenAndServe failed:", err)
+    log.Fatal("Could not load certificate: ", err)
+  }
+  go certs.watch()
+
+  if cfg.RedirectAddr != "" {
+    go func() {
+      fmt.Println("Redirecting to TLS from ", cfg.RedirectAddr)
+      err := http.ListenAndServe(cfg.RedirectAddr, redirectToTLS(cfg.Addr))
+      if err != nil {
+        log.Fatal("Redirect ListenAndServe failed:", err)
+      }
+    }()
+  }
+
+  server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+
+  fmt.Println("Listenting with TLS on ", cfg.Addr)
+  go func() {
+    err := server.ListenAndServeTLS("", "")
+    if err != http.ErrServerClosed {
+      log.Fatal("ListenAndServeTLS failed:", err)
+    }
+  }()
+  waitForShutdown(cfg, hub, server)
+}
+
+// waitForShutdown blocks until SIGTERM or SIGINT, then stops taking new
+// sockets, tells every client to come back later, waits for their queued
+// frames to flush and writes the games in progress to the snapshot file.
+func waitForShutdown(cfg *Config, hub *Hub, server *http.Server) {
+  stop := make(chan os.Signal, 1)
+  signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+  sig := <-stop
+  fmt.Println("Shutting down on ", sig)
+
+  atomic.StoreInt32(&draining, 1)
+
+  ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+  defer cancel()
+
+  msg, err := marshalMessage("SERVER_SHUTDOWN", protocol.ShutdownNotice{
+    Message: "Server restarting",
+    ReconnectAfterMs: int64(cfg.ReconnectAfter / time.Millisecond),
+  })
+  if err != nil {
+    log.Println("Could not make shutdown notice: ", err)
+  }
+  hub.Shutdown(msg)
+
+  flushed := make(chan struct{})
+  go func() {
+    hub.Wait()
+    close(flushed)
+  }()
+  select {
+  case <-flushed:
+  case <-ctx.Done():
+    log.Println("Gave up waiting for sockets to flush")
+  }
+
+  // hijacked websockets are not tracked by the server, and the event
+  // streams and polls of the fallback ended with their clients, this only
+  // stops the listener
+  if err := server.Shutdown(ctx); err != nil {
+    log.Println("Server shutdown: ", err)
+  }
+
+  if cfg.SnapshotFile != "" {
+    n, err := game.SaveSnapshot(cfg.SnapshotFile)
+    if err != nil {
+      log.Println("Could not save games: ", err)
+      return
+    }
+    fmt.Println("Saved games: ", n)
   }
 }
 
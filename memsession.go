@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gogo-sockets/protocol"
+)
+
+// memAddr is the remote IP of in-memory sessions, for the connection
+// limits.
+const memAddr = "memory"
+
+// memSession is a Session in memory, for running clients without a
+// network: the peer side sends with Send and reads what the server sent
+// with Next. A whole game can be played through the hub and the handlers
+// this way, one memSession per player.
+type memSession struct {
+	// Messages from the peer, and for it.
+	in  chan []byte
+	out chan []byte
+
+	// Closed with the session, and why, see CloseWith.
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	code   int
+	reason string
+}
+
+func newMemSession() *memSession {
+	return &memSession{
+		in:   make(chan []byte),
+		out:  make(chan []byte, 256),
+		done: make(chan struct{}),
+	}
+}
+
+// connectMemory connects a client with the HELO over a new memSession,
+// like serveWs does over a websocket. The HELO goes in the legacy
+// framing, later messages in the one it asks for. It needs the message
+// router main sets up.
+func connectMemory(hub *Hub, hello protocol.Hello) (*memSession, error) {
+	if err := hub.guard.admit(memAddr); err != nil {
+		return nil, err
+	}
+
+	msg, err := protocol.Encode(protocol.HeaderHello, hello)
+	if err != nil {
+		hub.guard.release(memAddr)
+		return nil, err
+	}
+
+	s := newMemSession()
+	registered := make(chan bool, 1)
+	go func() {
+		registered <- authAndRegister(hub, s, memAddr)
+	}()
+
+	if err := s.Send(msg); err != nil {
+		hub.guard.release(memAddr)
+		return nil, err
+	}
+	if !<-registered {
+		hub.guard.release(memAddr)
+		return nil, errors.New("Handshake refused")
+	}
+	return s, nil
+}
+
+func (s *memSession) Read() ([]byte, bool, error) {
+	select {
+	case msg := <-s.in:
+		return msg, false, nil
+	case <-s.done:
+		return nil, false, errSessionClosed
+	}
+}
+
+func (s *memSession) Write(msg []byte, binary bool) error {
+	select {
+	case s.out <- msg:
+		return nil
+	case <-s.done:
+		return errSessionClosed
+	}
+}
+
+func (s *memSession) Ping() error {
+	return nil
+}
+
+func (s *memSession) CloseWith(code int, reason string) error {
+	s.mu.Lock()
+	if s.code == 0 {
+		s.code, s.reason = code, reason
+	}
+	s.mu.Unlock()
+
+	return s.Close()
+}
+
+func (s *memSession) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
+// Send is the peer sending a message, it blocks until the readPump takes
+// it.
+func (s *memSession) Send(msg []byte) error {
+	select {
+	case s.in <- msg:
+		return nil
+	case <-s.done:
+		return errSessionClosed
+	}
+}
+
+// Next is the next message the server sent the peer, it fails if none
+// comes within the timeout.
+func (s *memSession) Next(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-s.out:
+		return msg, nil
+	case <-timer.C:
+		return nil, errors.New("No message in time")
+	}
+}
+
+// Closed says if the session is closed, with the code and reason the
+// server gave.
+func (s *memSession) Closed() (int, string, bool) {
+	select {
+	case <-s.done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.code, s.reason, true
+	default:
+		return 0, "", false
+	}
+}
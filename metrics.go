@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gogo-sockets/game"
+)
+
+// Metrics in the Prometheus text exposition format, served on /metrics.
+var (
+	// Clients registered with the hub, detached clients are not counted.
+	connectedClients int64
+
+	messagesIn = newCounterVec("gogo_messages_in_total",
+		"Messages received from clients, by header.", "header")
+	messagesOut = newCounterVec("gogo_messages_out_total",
+		"Frames written to clients, by header.", "header")
+	sendDrops = newCounterVec("gogo_send_drops_total",
+		"Messages replaced by a newer one while a client's Send buffer was full.", "")
+	slowEvictions = newCounterVec("gogo_slow_evictions_total",
+		"Clients disconnected for not keeping up, by the class of message that gave up on them.", "class")
+	handled = newCounterVec("gogo_handled_total",
+		"Messages handled, by route.", "route")
+	handlerErrors = newCounterVec("gogo_handler_errors_total",
+		"Errors sent back to clients by handlers, by code.", "code")
+	questionsAnswered = newCounterVec("gogo_questions_answered_total",
+		"Questions answered, by whether the answer was correct.", "correct")
+	rateLimited = newCounterVec("gogo_rate_limited_total",
+		"Messages refused by the rate limits, by header.", "header")
+	abuseDisconnects = newCounterVec("gogo_abuse_disconnects_total",
+		"Clients disconnected for going over their rate limits too often.", "")
+	authFailures = newCounterVec("gogo_auth_failures_total",
+		"HELO messages whose credentials were refused.", "")
+
+	buzzLatency = newHistogram("gogo_buzz_latency_seconds",
+		"Delay reported with each buzz that did not time out.",
+		[]float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 30})
+)
+
+// counterVec is a counter split by the values of one label. An empty label
+// makes it a plain counter.
+type counterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+}
+
+func (c *counterVec) inc(value string) {
+	c.add(value, 1)
+}
+
+func (c *counterVec) add(value string, n uint64) {
+	c.mu.Lock()
+	c.values[value] += n
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	writeMeta(w, c.name, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %d\n", c.name, c.values[""])
+		return
+	}
+
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", c.name, c.label, escapeLabel(v), c.values[v])
+	}
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer) {
+	writeMeta(w, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// serveMetrics writes every metric in the text exposition format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMeta(w, "gogo_connected_clients", "Clients connected to the hub.", "gauge")
+	fmt.Fprintf(w, "gogo_connected_clients %d\n", atomic.LoadInt64(&connectedClients))
+
+	writeGames(w)
+
+	for _, c := range []*counterVec{messagesIn, messagesOut, handled, handlerErrors, sendDrops, slowEvictions, rateLimited, abuseDisconnects, questionsAnswered, authFailures} {
+		c.write(w)
+	}
+	buzzLatency.write(w)
+}
+
+// writeGames writes the number of games in each state.
+func writeGames(w io.Writer) {
+	counts := make(map[game.GameState]int)
+	if gls, err := game.AllGames(); err == nil {
+		for _, g := range gls {
+			counts[g.State]++
+		}
+	}
+
+	writeMeta(w, "gogo_games", "Games in memory, by state.", "gauge")
+	for _, state := range game.GameStates() {
+		fmt.Fprintf(w, "gogo_games{state=\"%s\"} %d\n", state, counts[state])
+	}
+}
+
+func writeMeta(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(v)
+}
+
+// frameHeader is the message type of a frame, for the message counters.
+func frameHeader(frame []byte) string {
+	if len(frame) < headerTypeLen {
+		return ""
+	}
+	return strings.TrimSpace(string(frame[:headerTypeLen]))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"gogo-sockets/game"
+)
+
+// recoverPanics turns a panicking handler into an INTERNAL error for the
+// client instead of a crashed server.
+func recoverPanics(next Handler) Handler {
+	return func(r *Request) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Panic handling %s from %s: %v\n%s", r.Route, r.Client.Id(), p, debug.Stack())
+				err = game.NewError(game.Internal, "Internal error")
+			}
+		}()
+
+		return next(r)
+	}
+}
+
+// logRequests logs every message with how long it took and how it went.
+func logRequests(next Handler) Handler {
+	return func(r *Request) error {
+		start := time.Now()
+		err := next(r)
+
+		if err != nil {
+			log.Printf("%s from %s (request %q) failed in %v: %v", r.Route, r.Client.Id(), r.Id, time.Since(start), err)
+		} else {
+			log.Printf("%s from %s (request %q) handled in %v", r.Route, r.Client.Id(), r.Id, time.Since(start))
+		}
+		return err
+	}
+}
+
+// countRequests counts the handled messages by route, and the errors by
+// code.
+func countRequests(next Handler) Handler {
+	return func(r *Request) error {
+		err := next(r)
+
+		handled.inc(r.Route)
+		if err != nil {
+			handlerErrors.inc(errorCode(err))
+		}
+		return err
+	}
+}
+
+// inGame looks up the game named by the gameId in the body, for the
+// handlers of messages about a game. The rest of the body is left to the
+// handler to check.
+func inGame(next Handler) Handler {
+	return func(r *Request) error {
+		body := struct {
+			GameId string `json:"gameId"`
+		}{}
+		if err := json.Unmarshal(r.Body, &body); err != nil {
+			return err
+		}
+
+		g, ok := game.GetGame(body.GameId)
+		if !ok {
+			return game.NewError(game.UnknownGame, "Unknown gameId: %v", body.GameId)
+		}
+
+		r.Game = g
+		return next(r)
+	}
+}
+
+// seated only lets players of the game through. Needs inGame.
+func seated(next Handler) Handler {
+	return func(r *Request) error {
+		if err := r.Game.CheckSeat(r.Client.Id()); err != nil {
+			return err
+		}
+		return next(r)
+	}
+}
+
+// onTurn only lets the current player through. Needs inGame.
+func onTurn(next Handler) Handler {
+	return func(r *Request) error {
+		if err := r.Game.CheckTurn(r.Client.Id()); err != nil {
+			return err
+		}
+		return next(r)
+	}
+}
+
+// capable only lets clients with the capability through, for the others
+// the request does not exist.
+func capable(capability string) Middleware {
+	return func(next Handler) Handler {
+		return func(r *Request) error {
+			if !r.Client.Can(capability) {
+				return newClientError(errUnknownRequest, "Unknown request %q, needs the %s capability", r.Route, capability)
+			}
+			return next(r)
+		}
+	}
+}
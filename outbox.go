@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// outbox numbers the frames sent to a client and keeps the last
+// -replay-size of them, so a reconnecting client can be sent what it
+// missed. It outlives the Client while the player's seat is
+// held, so the sequence carries on across a reconnect.
+type outbox struct {
+	mu sync.Mutex
+
+	// Sequence id of the last frame stamped, the first frame is 1.
+	seq uint64
+
+	// Ring of stamped frames, frame n sits at n % len(frames).
+	frames [][]byte
+}
+
+func newOutbox(size int) *outbox {
+	return &outbox{frames: make([][]byte, size)}
+}
+
+// stamp returns a copy of the message with the next sequence id written
+// into its header, and keeps it for replay.
+func (o *outbox) stamp(msg []byte) []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	frame := make([]byte, len(msg))
+	copy(frame, msg)
+	copy(frame[headerTypeLen:headerLen], fmt.Sprintf("%*d", headerLen-headerTypeLen, o.seq))
+
+	o.frames[o.seq%uint64(len(o.frames))] = frame
+	return frame
+}
+
+// since returns the frames stamped after lastSeq. It returns false when
+// some of them already fell out of the buffer, or lastSeq was never sent,
+// and the client has to resync from a snapshot instead.
+func (o *outbox) since(lastSeq uint64) ([][]byte, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	size := uint64(len(o.frames))
+	if lastSeq > o.seq || o.seq-lastSeq > size {
+		return nil, false
+	}
+
+	if lastSeq == o.seq {
+		return nil, true
+	}
+
+	frames := make([][]byte, 0, o.seq-lastSeq)
+	for n := lastSeq + 1; n <= o.seq; n++ {
+		frames = append(frames, o.frames[n%size])
+	}
+
+	return frames, true
+}
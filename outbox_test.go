@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"gogo-sockets/protocol"
+)
+
+func TestOutboxSince(t *testing.T) {
+	o := newOutbox(4)
+	for i := 1; i <= 6; i++ {
+		msg, _ := protocol.EncodeRaw("GAMES", []byte(fmt.Sprint(i)))
+		o.stamp(msg)
+	}
+
+	tests := []struct {
+		name    string
+		lastSeq uint64
+		want    []uint64
+		ok      bool
+	}{
+		{"up to date", 6, nil, true},
+		{"missed some", 3, []uint64{4, 5, 6}, true},
+		{"missed all that is kept", 2, []uint64{3, 4, 5, 6}, true},
+		{"fell out of the buffer", 1, nil, false},
+		{"never sent", 7, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, ok := o.since(tt.lastSeq)
+			if ok != tt.ok {
+				t.Fatalf("since(%d) ok = %v, want %v", tt.lastSeq, ok, tt.ok)
+			}
+			if len(frames) != len(tt.want) {
+				t.Fatalf("since(%d) returned %d frames, want %d", tt.lastSeq, len(frames), len(tt.want))
+			}
+			for i, frame := range frames {
+				f, err := protocol.Decode(frame)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Seq != tt.want[i] || string(f.Body) != fmt.Sprint(tt.want[i]) {
+					t.Errorf("frame %d is seq %d body %s, want %d", i, f.Seq, f.Body, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"gogo-sockets/game"
+)
+
+// The bodies of the admin API, see the server's adminAPI. Errors come as
+// an Error, announcements as an Announcement.
+
+// GameDetail is a game as the admin API shows it, with its current
+// question and buzzes.
+type GameDetail struct {
+	Game     *game.Game          `json:"game"`
+	Question *game.QuestionState `json:"question"`
+}
+
+// KickResult is the reply to a kick: how many connections of the client
+// were closed, and whether it gave up a seat.
+type KickResult struct {
+	ClientId    string `json:"clientId"`
+	Connections int    `json:"connections"`
+	Seat        bool   `json:"seat"`
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Frame is a decoded message.
+type Frame struct {
+	Header string
+
+	// Sequence id, 0 for frames from clients.
+	Seq uint64
+
+	// The requestId, when the framing carries it outside the body.
+	Id string
+
+	Body []byte
+}
+
+// BodyError is a body that does not decode into the message it should be.
+type BodyError struct {
+	Err error
+}
+
+func (e *BodyError) Error() string {
+	return "Invalid body: " + e.Err.Error()
+}
+
+func (e *BodyError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeRaw builds a frame from a header and an encoded body, with the
+// sequence id left blank.
+func EncodeRaw(header string, body []byte) ([]byte, error) {
+	if len(header) > HeaderTypeLen {
+		return nil, fmt.Errorf("Header %q longer than %d bytes", header, HeaderTypeLen)
+	}
+
+	frame := make([]byte, 0, HeaderLen+len(body))
+	frame = append(frame, fmt.Sprintf("%-*s", HeaderLen, header)...)
+	return append(frame, body...), nil
+}
+
+// EncodeFrame builds a legacy frame, with the sequence id if it has one.
+func EncodeFrame(f Frame) ([]byte, error) {
+	frame, err := EncodeRaw(f.Header, f.Body)
+	if err != nil || f.Seq == 0 {
+		return frame, err
+	}
+
+	copy(frame[HeaderTypeLen:HeaderLen], fmt.Sprintf("%*d", HeaderLen-HeaderTypeLen, f.Seq))
+	return frame, nil
+}
+
+// Encode builds a frame with the body encoded as JSON.
+func Encode(header string, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeRaw(header, data)
+}
+
+// Decode splits a frame into its header, sequence id and body. The body
+// is not decoded, see DecodeBody.
+func Decode(frame []byte) (Frame, error) {
+	if len(frame) < HeaderLen {
+		return Frame{}, ErrShortFrame
+	}
+
+	f := Frame{
+		Header: string(bytes.TrimSpace(frame[:HeaderTypeLen])),
+		Body:   frame[HeaderLen:],
+	}
+	if seq := bytes.TrimSpace(frame[HeaderTypeLen:HeaderLen]); len(seq) > 0 {
+		n, err := strconv.ParseUint(string(seq), 10, 64)
+		if err != nil {
+			return Frame{}, &BodyError{fmt.Errorf("Invalid sequence id %q", seq)}
+		}
+		f.Seq = n
+	}
+
+	return f, nil
+}
+
+// DecodeBody decodes a JSON body into v, which must describe every field
+// in it: unknown fields, and anything after the body, are errors.
+func DecodeBody(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return &BodyError{err}
+	}
+	if dec.More() {
+		return &BodyError{errors.New("data after the body")}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Framings, how frames are put in websocket messages. Clients ask for one
+// in HELO, the server says which it picked in WELCOME.
+const (
+	// The 32 byte header in front of the body. Batched frames are
+	// separated by newlines.
+	FramingLegacy = "legacy"
+
+	// Each frame is a JSON object, see Envelope. Batched frames are sent
+	// as a JSON array of them. Needs version 2.
+	FramingEnvelope = "envelope"
+
+	// Each frame is a MessagePack map with the fields of an Envelope, in
+	// binary websocket messages. Batched frames are sent as an array of
+	// them. Needs version 2.
+	FramingMsgpack = "msgpack"
+)
+
+// Framing turns frames into websocket messages and back.
+type Framing interface {
+	// Name is how the framing is asked for in HELO.
+	Name() string
+
+	// Encode builds one websocket message out of one or more frames.
+	Encode(frames []Frame) ([]byte, error)
+
+	// Decode reads the frames out of a websocket message.
+	Decode(msg []byte) ([]Frame, error)
+
+	// Binary says if the messages go in binary websocket messages rather
+	// than text ones.
+	Binary() bool
+}
+
+var framings = map[string]Framing{
+	FramingLegacy:   legacyFraming{},
+	FramingEnvelope: envelopeFraming{},
+	FramingMsgpack:  msgpackFraming{},
+}
+
+// FramingFor returns the framing of the name, the empty name is legacy.
+func FramingFor(name string) (Framing, bool) {
+	if name == "" {
+		name = FramingLegacy
+	}
+	f, ok := framings[name]
+	return f, ok
+}
+
+// ErrShortFrame is a legacy frame without a full header.
+var ErrShortFrame = fmt.Errorf("Invalid message length, must be > %d", HeaderLen-1)
+
+type legacyFraming struct{}
+
+func (legacyFraming) Name() string {
+	return FramingLegacy
+}
+
+func (legacyFraming) Encode(frames []Frame) ([]byte, error) {
+	var b bytes.Buffer
+	for i, f := range frames {
+		frame, err := EncodeFrame(f)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.Write(frame)
+	}
+	return b.Bytes(), nil
+}
+
+// Decode reads the one frame of the message, clients do not batch.
+func (legacyFraming) Decode(msg []byte) ([]Frame, error) {
+	f, err := Decode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return []Frame{f}, nil
+}
+
+func (legacyFraming) Binary() bool {
+	return false
+}
+
+// Envelope is a frame in envelope framing. Type is the message header.
+type Envelope struct {
+	Type string `json:"type"`
+
+	// The requestId, of a request or of the request a reply answers.
+	Id string `json:"id,omitempty"`
+
+	// Sequence id, for frames sent by the server.
+	Seq uint64 `json:"seq,omitempty"`
+
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+type envelopeFraming struct{}
+
+func (envelopeFraming) Name() string {
+	return FramingEnvelope
+}
+
+func (envelopeFraming) Encode(frames []Frame) ([]byte, error) {
+	envs := make([]Envelope, len(frames))
+	for i, f := range frames {
+		envs[i] = Envelope{Type: f.Header, Id: f.Id, Seq: f.Seq, Body: f.Body}
+	}
+
+	if len(envs) == 1 {
+		return json.Marshal(envs[0])
+	}
+	return json.Marshal(envs)
+}
+
+// Decode reads an envelope, or a JSON array of them.
+func (envelopeFraming) Decode(msg []byte) ([]Frame, error) {
+	msg = bytes.TrimSpace(msg)
+
+	var envs []Envelope
+	if len(msg) > 0 && msg[0] == '[' {
+		if err := DecodeBody(msg, &envs); err != nil {
+			return nil, err
+		}
+	} else {
+		var env Envelope
+		if err := DecodeBody(msg, &env); err != nil {
+			return nil, err
+		}
+		envs = []Envelope{env}
+	}
+
+	frames := make([]Frame, len(envs))
+	for i, env := range envs {
+		if env.Type == "" || len(env.Type) > HeaderTypeLen {
+			return nil, &BodyError{fmt.Errorf("Invalid type %q", env.Type)}
+		}
+		frames[i] = Frame{Header: env.Type, Id: env.Id, Seq: env.Seq, Body: env.Body}
+	}
+	return frames, nil
+}
+
+func (envelopeFraming) Binary() bool {
+	return false
+}
+
+// DetectFraming guesses the framing of a message from its websocket type
+// and first byte, for the HELO, which comes before anything is negotiated.
+func DetectFraming(msg []byte, binary bool) Framing {
+	if binary {
+		return msgpackFraming{}
+	}
+
+	trimmed := bytes.TrimSpace(msg)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return envelopeFraming{}
+	}
+	return legacyFraming{}
+}
+
+// RequestIdOf reads the requestId the server puts first in the bodies of
+// replies, see WithRequestId. Other bodies have none.
+func RequestIdOf(body []byte) string {
+	const prefix = `{"requestId":`
+	if !bytes.HasPrefix(body, []byte(prefix)) {
+		return ""
+	}
+
+	var id string
+	dec := json.NewDecoder(bytes.NewReader(body[len(prefix):]))
+	if dec.Decode(&id) != nil {
+		return ""
+	}
+	return id
+}
+
+// WithRequestId adds the requestId to the front of a JSON object body,
+// other bodies are returned as they are.
+func WithRequestId(body []byte, requestId string) []byte {
+	if requestId == "" || len(body) < 2 || body[0] != '{' {
+		return body
+	}
+
+	id, _ := json.Marshal(requestId)
+	out := append([]byte(`{"requestId":`), id...)
+	if len(body) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, body[1:]...)
+}
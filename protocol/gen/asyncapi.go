@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"gogo-sockets/protocol"
+)
+
+// object is a JSON object of the document.
+type object map[string]interface{}
+
+const schemaRef = "#/components/schemas/"
+
+// asyncAPI builds the AsyncAPI document. Message bodies are described with
+// JSON Schema, under components.schemas.
+func (g *generator) asyncAPI() []byte {
+	schemas := object{}
+	for _, t := range g.types {
+		schemas[t.Name()] = g.definition(t)
+	}
+
+	messages := object{}
+	var publish, subscribe []interface{}
+	for _, m := range protocol.Messages {
+		key := strings.Replace(m.Name(), "/", ".", 1)
+		messages[key] = g.message(m)
+
+		ref := object{"$ref": "#/components/messages/" + key}
+		if m.Direction == protocol.FromClient {
+			publish = append(publish, ref)
+		} else {
+			subscribe = append(subscribe, ref)
+		}
+	}
+
+	doc := object{
+		"asyncapi": "2.6.0",
+		"info": object{
+			"title":       "gogo-sockets",
+			"version":     fmt.Sprint(protocol.Version),
+			"description": g.docs["protocol"],
+		},
+		"defaultContentType": "application/json",
+		"channels": object{
+			"/ws": object{
+				"description": "The websocket. In the legacy framing every message is a frame of a " +
+					fmt.Sprint(protocol.HeaderLen) + " byte header and a JSON body, the message header is the name of the message. " +
+					"In the envelope framing it is an Envelope, or an array of them, and in the msgpack framing the same in MessagePack, in binary messages.",
+				"publish": object{
+					"summary": "Messages the clients send.",
+					"message": object{"oneOf": publish},
+				},
+				"subscribe": object{
+					"summary": "Messages the server sends.",
+					"message": object{"oneOf": subscribe},
+				},
+			},
+		},
+		"components": object{
+			"messages": messages,
+			"schemas":  schemas,
+		},
+		"x-frame": object{
+			"headerLen":     protocol.HeaderLen,
+			"headerTypeLen": protocol.HeaderTypeLen,
+		},
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		fail(err)
+	}
+	return b.Bytes()
+}
+
+func (g *generator) message(m protocol.Message) object {
+	msg := object{
+		"name":     m.Name(),
+		"x-header": m.Header,
+	}
+	if doc := g.messageDoc(m); doc != "" {
+		msg["summary"] = doc
+	}
+	if m.Body == nil {
+		return msg
+	}
+
+	payload := g.schema(reflect.TypeOf(m.Body))
+	if m.Field != "" {
+		payload = object{"allOf": []interface{}{payload, object{
+			"properties": object{m.Field: object{"const": m.Value}},
+			"required":   []string{m.Field},
+		}}}
+	}
+	if m.Reply {
+		payload = object{"allOf": []interface{}{payload, object{"$ref": schemaRef + "Meta"}}}
+	}
+	msg["payload"] = payload
+
+	return msg
+}
+
+// definition is the schema of a named type, the other schemas refer to it.
+func (g *generator) definition(t reflect.Type) object {
+	var s object
+	if values, ok := g.enums[t]; ok {
+		nums := make([]interface{}, len(values))
+		names := make([]string, len(values))
+		for i, v := range values {
+			nums[i] = reflect.ValueOf(v).Int()
+			names[i] = v.String()
+		}
+		s = object{"type": "integer", "enum": nums, "x-enum-names": names}
+	} else {
+		props := object{}
+		required := []string{}
+		for _, f := range g.fields(t) {
+			p := g.schema(f.Type)
+			if f.Nullable {
+				p = object{"oneOf": []interface{}{p, object{"type": "null"}}}
+			}
+			if f.Doc != "" && isPlain(p) {
+				p = withDescription(p, f.Doc)
+			} else if f.Doc != "" {
+				p = object{"allOf": []interface{}{p}, "description": f.Doc}
+			}
+			props[f.Name] = p
+			if !f.Optional {
+				required = append(required, f.Name)
+			}
+		}
+
+		s = object{"type": "object", "properties": props, "required": required}
+		if g.strict[t] {
+			s["additionalProperties"] = false
+		}
+	}
+
+	if doc := g.doc(t); doc != "" {
+		s["description"] = doc
+	}
+	return s
+}
+
+// schema is the schema of a type, referring to the named ones.
+func (g *generator) schema(t reflect.Type) object {
+	if _, ok := g.enums[t]; ok {
+		return object{"$ref": schemaRef + t.Name()}
+	}
+
+	if t == rawJSON {
+		return object{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Struct:
+		return object{"$ref": schemaRef + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return object{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		bits := t.Bits() - 1
+		return object{"type": "integer", "minimum": -int64(1) << bits, "maximum": int64(1)<<bits - 1}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return object{"type": "integer", "minimum": 0, "maximum": uint64(1)<<t.Bits() - 1}
+	case reflect.Uint, reflect.Uint64:
+		return object{"type": "integer", "minimum": 0, "maximum": uint64(math.MaxUint64)}
+	case reflect.Int, reflect.Int64:
+		return object{"type": "integer"}
+	}
+
+	panic(fmt.Sprintf("gen: no schema for %v", t))
+}
+
+// isPlain is a schema that is not a reference, so it can be described in
+// place. JSON Schema ignores the siblings of $ref.
+func isPlain(s object) bool {
+	_, ref := s["$ref"]
+	return !ref
+}
+
+func withDescription(s object, doc string) object {
+	out := object{"description": doc}
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// docs holds doc comments by "package.Type" and "package.Type.Field", and
+// the package comments by package name.
+type docs map[string]string
+
+func readDocs(dirs []string) (docs, error) {
+	d := docs{}
+	fset := token.NewFileSet()
+
+	for _, dir := range dirs {
+		pkgs, err := parser.ParseDir(fset, dir, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, pkg := range pkgs {
+			for _, file := range pkg.Files {
+				if file.Doc != nil {
+					d[name] = clean(file.Doc)
+				}
+				d.readFile(name, file)
+			}
+		}
+	}
+
+	return d, nil
+}
+
+func (d docs) readFile(pkg string, file *ast.File) {
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			key := pkg + "." + ts.Name.Name
+
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			d[key] = clean(doc)
+
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			for _, f := range st.Fields.List {
+				doc := f.Doc
+				if doc == nil {
+					doc = f.Comment
+				}
+				for _, n := range f.Names {
+					d[key+"."+n.Name] = clean(doc)
+				}
+			}
+		}
+	}
+}
+
+// clean returns the text of the comment, without the notes meant for the
+// people working on the code.
+func clean(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(cg.Text()), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "TODO") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,66 @@
+// gen writes the AsyncAPI document and the TypeScript definitions of the
+// protocol, from protocol.Messages and the Go types of the bodies. Doc
+// comments are read from the source of the packages the types are in.
+//
+//	go generate ./protocol
+//
+// With -check it writes nothing and fails if the files are out of date.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var out = flag.String("out", "spec", "directory the files are written to")
+var src = flag.String("src", ".,../game", "comma separated package directories to read doc comments from")
+var check = flag.Bool("check", false, "fail if the files are out of date instead of writing them")
+
+func main() {
+	flag.Parse()
+
+	docs, err := readDocs(strings.Split(*src, ","))
+	if err != nil {
+		fail(err)
+	}
+
+	g := newGenerator(docs)
+	files := map[string][]byte{
+		"asyncapi.json": g.asyncAPI(),
+		"protocol.ts":   g.typeScript(),
+	}
+
+	stale := false
+	for name, data := range files {
+		path := filepath.Join(*out, name)
+		if *check {
+			old, err := ioutil.ReadFile(path)
+			if err != nil || !bytes.Equal(old, data) {
+				fmt.Fprintln(os.Stderr, path, "is out of date, run go generate ./protocol")
+				stale = true
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			fail(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			fail(err)
+		}
+	}
+
+	if stale {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gen:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// generator walks the types of the message bodies.
+type generator struct {
+	docs docs
+
+	// The values of the numeric enums, which reflection cannot list.
+	enums map[reflect.Type][]fmt.Stringer
+
+	// Named types the bodies use, in the order they are first reached.
+	types []reflect.Type
+	names map[string]reflect.Type
+
+	// Types in the bodies clients send, which must not have other fields.
+	strict map[reflect.Type]bool
+}
+
+// field is a field of a body, as it is on the wire.
+type field struct {
+	Name     string
+	Type     reflect.Type
+	Optional bool
+	Nullable bool
+	Doc      string
+}
+
+func newGenerator(d docs) *generator {
+	g := &generator{
+		docs:   d,
+		enums:  map[reflect.Type][]fmt.Stringer{},
+		names:  map[string]reflect.Type{},
+		strict: map[reflect.Type]bool{},
+	}
+	for _, s := range game.GameStates() {
+		t := reflect.TypeOf(s)
+		g.enums[t] = append(g.enums[t], s)
+	}
+
+	// replies add the requestId to the body, envelope framing wraps it
+	g.collect(reflect.TypeOf(protocol.Meta{}), false)
+	g.collect(reflect.TypeOf(protocol.Envelope{}), false)
+	for _, m := range protocol.Messages {
+		if m.Body != nil {
+			g.collect(reflect.TypeOf(m.Body), m.Direction == protocol.FromClient)
+		}
+	}
+
+	return g
+}
+
+// collect adds t and the types it is made of.
+func (g *generator) collect(t reflect.Type, strict bool) {
+	if t == rawJSON {
+		return
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	_, enum := g.enums[t]
+	if t.Kind() != reflect.Struct && !enum {
+		return
+	}
+	if t.Name() == "" {
+		panic(fmt.Sprintf("gen: anonymous %v in a body, give it a name", t))
+	}
+	if other, ok := g.names[t.Name()]; ok {
+		if other != t {
+			panic(fmt.Sprintf("gen: %v and %v have the same name", t, other))
+		}
+		return
+	}
+
+	g.names[t.Name()] = t
+	g.types = append(g.types, t)
+	if strict {
+		g.strict[t] = true
+	}
+
+	if t.Kind() == reflect.Struct {
+		for _, f := range g.fields(t) {
+			g.collect(f.Type, strict)
+		}
+	}
+}
+
+// fields lists the fields of the struct as encoding/json sees them, with
+// the fields of embedded structs pulled up.
+func (g *generator) fields(t reflect.Type) []field {
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+
+		if f.Anonymous && name == "" && deref(f.Type).Kind() == reflect.Struct {
+			fs = append(fs, g.fields(deref(f.Type))...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fs = append(fs, field{
+			Name:     name,
+			Type:     f.Type,
+			Optional: strings.Contains(opts, ",omitempty"),
+			Nullable: f.Type.Kind() == reflect.Ptr,
+			Doc:      g.docs[docKey(t)+"."+f.Name],
+		})
+	}
+	return fs
+}
+
+// doc is the doc comment of the named type.
+func (g *generator) doc(t reflect.Type) string {
+	return g.docs[docKey(deref(t))]
+}
+
+// messageDoc is the doc of the message, or of its body if it has none.
+func (g *generator) messageDoc(m protocol.Message) string {
+	if m.Doc != "" || m.Body == nil {
+		return m.Doc
+	}
+	return g.doc(reflect.TypeOf(m.Body))
+}
+
+func docKey(t reflect.Type) string {
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+// rawJSON is a field holding any JSON.
+var rawJSON = reflect.TypeOf(json.RawMessage{})
+
+func deref(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
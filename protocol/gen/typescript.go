@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gogo-sockets/protocol"
+)
+
+// typeScript builds the TypeScript definitions: an interface per body
+// type, and the bodies of the messages by name.
+func (g *generator) typeScript() []byte {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by go generate ./protocol. DO NOT EDIT.\n\n")
+
+	comment(&b, "", "Length of the header in front of every body.")
+	fmt.Fprintf(&b, "export const HEADER_LEN = %d;\n\n", protocol.HeaderLen)
+	comment(&b, "", "The message type takes the start of the header, the sequence id the rest.")
+	fmt.Fprintf(&b, "export const HEADER_TYPE_LEN = %d;\n", protocol.HeaderTypeLen)
+
+	for _, t := range g.types {
+		b.WriteString("\n")
+		comment(&b, "", g.doc(t))
+
+		if values, ok := g.enums[t]; ok {
+			fmt.Fprintf(&b, "export enum %s {\n", t.Name())
+			for _, v := range values {
+				fmt.Fprintf(&b, "  %s = %d,\n", strings.ToUpper(v.String()), reflect.ValueOf(v).Int())
+			}
+			b.WriteString("}\n")
+			continue
+		}
+
+		fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+		for _, f := range g.fields(t) {
+			comment(&b, "  ", f.Doc)
+			typ := g.tsType(f.Type)
+			if f.Nullable {
+				typ += " | null"
+			}
+			opt := ""
+			if f.Optional {
+				opt = "?"
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", f.Name, opt, typ)
+		}
+		b.WriteString("}\n")
+	}
+
+	g.messageMap(&b, protocol.FromClient, "ClientMessages", "Bodies of the messages clients send, by header, or header/request.")
+	g.messageMap(&b, protocol.FromServer, "ServerMessages", "Bodies of the messages the server sends, by header.")
+
+	return b.Bytes()
+}
+
+func (g *generator) messageMap(b *bytes.Buffer, dir protocol.Direction, name, doc string) {
+	b.WriteString("\n")
+	comment(b, "", doc)
+	fmt.Fprintf(b, "export interface %s {\n", name)
+	for _, m := range protocol.Messages {
+		if m.Direction != dir {
+			continue
+		}
+
+		typ := "null"
+		if m.Body != nil {
+			typ = g.tsType(reflect.TypeOf(m.Body))
+		}
+		if m.Field != "" {
+			typ += fmt.Sprintf(" & { %s: %q }", m.Field, m.Value)
+		}
+		if m.Reply {
+			typ += " & Meta"
+		}
+
+		comment(b, "  ", g.messageDoc(m))
+		fmt.Fprintf(b, "  %q: %s;\n", m.Name(), typ)
+	}
+	b.WriteString("}\n")
+}
+
+func (g *generator) tsType(t reflect.Type) string {
+	if _, ok := g.enums[t]; ok {
+		return t.Name()
+	}
+	if t == rawJSON {
+		return "unknown"
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.tsType(t.Elem())
+	case reflect.Struct:
+		return t.Name()
+	case reflect.Slice, reflect.Array:
+		return g.tsType(t.Elem()) + "[]"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+
+	panic(fmt.Sprintf("gen: no TypeScript type for %v", t))
+}
+
+func comment(b *bytes.Buffer, indent, doc string) {
+	if doc == "" {
+		return
+	}
+
+	lines := strings.Split(doc, "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, doc)
+		return
+	}
+
+	fmt.Fprintf(b, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(b, "%s * %s\n", indent, line)
+	}
+	fmt.Fprintf(b, "%s */\n", indent)
+}
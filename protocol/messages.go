@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"gogo-sockets/game"
+)
+
+//go:generate go run ./gen
+
+// Direction says who sends a message.
+type Direction string
+
+const (
+	FromClient Direction = "client"
+	FromServer Direction = "server"
+)
+
+// Message describes one message of the protocol. Messages lists them all,
+// the spec and the TypeScript definitions in spec/ are generated from it.
+type Message struct {
+	Header    string
+	Direction Direction
+
+	// For headers carrying several requests, the body field naming the
+	// request and its value for this message.
+	Field string
+	Value string
+
+	// A value of the type of the body, nil for messages without one.
+	Body interface{}
+
+	// Sent as the direct reply to a request, with its requestId added to
+	// the body.
+	Reply bool
+
+	Doc string
+}
+
+// Name is the header, with the request for headers carrying several.
+func (m Message) Name() string {
+	if m.Value == "" {
+		return m.Header
+	}
+	return m.Header + "/" + m.Value
+}
+
+// Messages is every message of the protocol.
+var Messages = []Message{
+	{Header: HeaderHello, Direction: FromClient, Body: Hello{},
+		Doc: "First message on every connection, anything else closes it."},
+	{Header: HeaderInit, Direction: FromClient,
+		Doc: "Asks for the lobby, the reply is GAMES. The server does this itself after HELO."},
+	{Header: HeaderGameReq, Field: "action", Value: ActionCreate, Direction: FromClient, Body: GameRequest{},
+		Doc: "Creates a game with the sender as its host, the reply is START_WAIT."},
+	{Header: HeaderGameReq, Field: "action", Value: ActionJoin, Direction: FromClient, Body: GameRequest{},
+		Doc: "Takes a seat in a waiting game. The players get START_WAIT, or START_ROUND for the third player."},
+	{Header: HeaderGameReq, Field: "action", Value: ActionLeave, Direction: FromClient, Body: GameRequest{},
+		Doc: "Gives up a seat and goes back to the lobby."},
+	{Header: HeaderGameReq, Field: "action", Value: ActionSpectate, Direction: FromClient, Body: GameRequest{},
+		Doc: "Watches a game without a seat, the reply is SPECTATING."},
+	{Header: HeaderGameReq, Field: "action", Value: ActionUnspectate, Direction: FromClient, Body: GameRequest{},
+		Doc: "Stops watching a game, the reply is GAMES."},
+	{Header: HeaderBeginGame, Direction: FromClient, Body: BeginGame{},
+		Doc: "Sets the number of questions and starts the first round."},
+	{Header: HeaderNextRound, Direction: FromClient, Body: NextRound{},
+		Doc: "Starts the next round."},
+	{Header: HeaderGameplay, Field: "request", Value: RequestWheelSpin, Direction: FromClient, Body: WheelSpin{},
+		Doc: "The current player spun the wheel."},
+	{Header: HeaderGameplay, Field: "request", Value: RequestQuestionSelect, Direction: FromClient, Body: QuestionSelect{},
+		Doc: "The current player picked a question."},
+	{Header: HeaderGameplay, Field: "request", Value: RequestBuzz, Direction: FromClient, Body: Buzz{},
+		Doc: "A player buzzed, or their time ran out."},
+	{Header: HeaderGameplay, Field: "request", Value: RequestAnswer, Direction: FromClient, Body: Answer{},
+		Doc: "The player who won the buzz answered."},
+
+	{Header: HeaderWelcome, Direction: FromServer, Body: Welcome{}},
+	{Header: HeaderGames, Direction: FromServer, Body: []*game.Game{},
+		Doc: "Every game. Sent to the lobby whenever a game changes."},
+	{Header: HeaderStartWait, Direction: FromServer, Body: &game.Game{}, Reply: true,
+		Doc: "The game is waiting for players."},
+	{Header: HeaderStartRound, Direction: FromServer, Body: &game.Game{},
+		Doc: "A round started."},
+	{Header: HeaderSpectating, Direction: FromServer, Body: &game.Game{}, Reply: true,
+		Doc: "The game the client is now watching."},
+	{Header: HeaderWheelSpun, Direction: FromServer, Body: WheelSpun{}},
+	{Header: HeaderQuestionResponse, Direction: FromServer, Body: QuestionResponse{}},
+	{Header: HeaderBuzzed, Direction: FromServer, Body: Buzzed{}},
+	{Header: HeaderPlayerSelected, Direction: FromServer, Body: PlayerSelected{}},
+	{Header: HeaderAnswerResponse, Direction: FromServer, Body: AnswerResponse{}},
+	{Header: HeaderResume, Direction: FromServer, Body: Resume{}},
+	{Header: HeaderPlayerDisconnected, Direction: FromServer, Body: SeatEvent{},
+		Doc: "A player dropped, their seat is held for a while."},
+	{Header: HeaderPlayerReconnected, Direction: FromServer, Body: SeatEvent{},
+		Doc: "A dropped player is back."},
+	{Header: HeaderPlayerLeft, Direction: FromServer, Body: SeatEvent{},
+		Doc: "A dropped player did not come back in time and lost their seat."},
+	{Header: HeaderGameEnded, Direction: FromServer, Body: GameEnded{}},
+	{Header: HeaderAnnouncement, Direction: FromServer, Body: Announcement{}},
+	{Header: HeaderServerShutdown, Direction: FromServer, Body: ShutdownNotice{}},
+	{Header: HeaderError, Direction: FromServer, Body: Error{}},
+	{Header: HeaderAck, Direction: FromServer, Body: Ack{}, Reply: true},
+}
@@ -0,0 +1,539 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A small MessagePack codec for the msgpack framing. Bodies are JSON on
+// the server, they are transcoded on the way in and out, so every message
+// type goes through it without a codec of its own.
+
+// How deeply bodies may nest.
+const msgpackMaxDepth = 32
+
+var errMsgpackShort = errors.New("MessagePack value cut short")
+
+type msgpackFraming struct{}
+
+func (msgpackFraming) Name() string {
+	return FramingMsgpack
+}
+
+// Encode writes each frame as a map with the fields of an Envelope, and
+// batches as an array of them.
+func (msgpackFraming) Encode(frames []Frame) ([]byte, error) {
+	var w msgpackWriter
+	if len(frames) > 1 {
+		w.writeArrayHeader(len(frames))
+	}
+	for _, f := range frames {
+		if err := w.writeEnvelope(f); err != nil {
+			return nil, err
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// Decode reads an envelope map, or an array of them.
+func (msgpackFraming) Decode(msg []byte) ([]Frame, error) {
+	r := &msgpackReader{data: msg}
+
+	n := 1
+	if len(msg) > 0 && isMsgpackArray(msg[0]) {
+		var err error
+		if n, err = r.readArrayLen(); err != nil {
+			return nil, &BodyError{err}
+		}
+	}
+
+	frames := make([]Frame, 0, n)
+	for i := 0; i < n; i++ {
+		f, err := r.readEnvelope()
+		if err != nil {
+			return nil, &BodyError{err}
+		}
+		frames = append(frames, f)
+	}
+	if r.pos != len(r.data) {
+		return nil, &BodyError{errors.New("data after the message")}
+	}
+
+	return frames, nil
+}
+
+func (msgpackFraming) Binary() bool {
+	return true
+}
+
+type msgpackWriter struct {
+	bytes.Buffer
+}
+
+func (w *msgpackWriter) writeEnvelope(f Frame) error {
+	n := 1
+	if f.Id != "" {
+		n++
+	}
+	if f.Seq != 0 {
+		n++
+	}
+	if len(f.Body) > 0 {
+		n++
+	}
+
+	w.writeMapHeader(n)
+	w.writeString("type")
+	w.writeString(f.Header)
+	if f.Id != "" {
+		w.writeString("id")
+		w.writeString(f.Id)
+	}
+	if f.Seq != 0 {
+		w.writeString("seq")
+		w.writeUint(f.Seq)
+	}
+	if len(f.Body) > 0 {
+		w.writeString("body")
+		dec := json.NewDecoder(bytes.NewReader(f.Body))
+		dec.UseNumber()
+		if err := w.writeJSON(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSON transcodes the next JSON value of dec, keeping the order of
+// object keys.
+func (w *msgpackWriter) writeJSON(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		// the length goes first, encode the elements aside
+		var inner msgpackWriter
+		n := 0
+		for ; dec.More(); n++ {
+			if v == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				inner.writeString(key.(string))
+			}
+			if err := inner.writeJSON(dec); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+
+		if v == '{' {
+			w.writeMapHeader(n)
+		} else {
+			w.writeArrayHeader(n)
+		}
+		w.Write(inner.Bytes())
+	case string:
+		w.writeString(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			w.writeInt(i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			w.writeUint(u)
+		} else if f, err := v.Float64(); err == nil {
+			w.writeFloat(f)
+		} else {
+			return err
+		}
+	case bool:
+		if v {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case nil:
+		w.WriteByte(0xc0)
+	}
+	return nil
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	switch n := len(s); {
+	case n < 32:
+		w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		w.WriteByte(0xd9)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(0xda)
+		w.writeBig(uint64(n), 2)
+	default:
+		w.WriteByte(0xdb)
+		w.writeBig(uint64(n), 4)
+	}
+	w.WriteString(s)
+}
+
+func (w *msgpackWriter) writeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		w.WriteByte(0xcc)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(0xcd)
+		w.writeBig(n, 2)
+	case n <= math.MaxUint32:
+		w.WriteByte(0xce)
+		w.writeBig(n, 4)
+	default:
+		w.WriteByte(0xcf)
+		w.writeBig(n, 8)
+	}
+}
+
+func (w *msgpackWriter) writeInt(n int64) {
+	switch {
+	case n >= 0:
+		w.writeUint(uint64(n))
+	case n >= -32:
+		w.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		w.WriteByte(0xd0)
+		w.WriteByte(byte(n))
+	case n >= math.MinInt16:
+		w.WriteByte(0xd1)
+		w.writeBig(uint64(n), 2)
+	case n >= math.MinInt32:
+		w.WriteByte(0xd2)
+		w.writeBig(uint64(n), 4)
+	default:
+		w.WriteByte(0xd3)
+		w.writeBig(uint64(n), 8)
+	}
+}
+
+func (w *msgpackWriter) writeFloat(f float64) {
+	w.WriteByte(0xcb)
+	w.writeBig(math.Float64bits(f), 8)
+}
+
+func (w *msgpackWriter) writeArrayHeader(n int) {
+	w.writeCollection(n, 0x90, 0xdc, 0xdd)
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	w.writeCollection(n, 0x80, 0xde, 0xdf)
+}
+
+func (w *msgpackWriter) writeCollection(n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(b16)
+		w.writeBig(uint64(n), 2)
+	default:
+		w.WriteByte(b32)
+		w.writeBig(uint64(n), 4)
+	}
+}
+
+// writeBig writes the low size bytes of n, big endian.
+func (w *msgpackWriter) writeBig(n uint64, size int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	w.Write(buf[8-size:])
+}
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) readEnvelope() (Frame, error) {
+	n, err := r.readMapLen()
+	if err != nil {
+		return Frame{}, err
+	}
+
+	var f Frame
+	for i := 0; i < n; i++ {
+		key, err := r.readString()
+		if err != nil {
+			return Frame{}, err
+		}
+
+		switch key {
+		case "type":
+			f.Header, err = r.readString()
+		case "id":
+			f.Id, err = r.readString()
+		case "seq":
+			f.Seq, err = r.readUint()
+		case "body":
+			var b bytes.Buffer
+			err = r.readJSON(&b, 0)
+			f.Body = b.Bytes()
+		default:
+			err = fmt.Errorf("unknown field %q", key)
+		}
+		if err != nil {
+			return Frame{}, err
+		}
+	}
+
+	if f.Header == "" || len(f.Header) > HeaderTypeLen {
+		return Frame{}, fmt.Errorf("Invalid type %q", f.Header)
+	}
+	return f, nil
+}
+
+// readJSON transcodes the next value to JSON.
+func (r *msgpackReader) readJSON(b *bytes.Buffer, depth int) error {
+	if depth > msgpackMaxDepth {
+		return errors.New("MessagePack value nested too deeply")
+	}
+
+	c, err := r.peek()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c == 0xc0:
+		r.pos++
+		b.WriteString("null")
+	case c == 0xc2 || c == 0xc3:
+		r.pos++
+		b.WriteString(strconv.FormatBool(c == 0xc3))
+	case c <= 0x7f || c >= 0xe0 || (c >= 0xcc && c <= 0xd3):
+		n, err := r.readNumber()
+		if err != nil {
+			return err
+		}
+		b.WriteString(n)
+	case c == 0xca || c == 0xcb:
+		f, err := r.readFloat()
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return errors.New("MessagePack float has no JSON form")
+		}
+		b.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case isMsgpackString(c):
+		s, err := r.readString()
+		if err != nil {
+			return err
+		}
+		quoted, _ := json.Marshal(s)
+		b.Write(quoted)
+	case isMsgpackArray(c):
+		n, err := r.readArrayLen()
+		if err != nil {
+			return err
+		}
+		b.WriteByte('[')
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := r.readJSON(b, depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case isMsgpackMap(c):
+		n, err := r.readMapLen()
+		if err != nil {
+			return err
+		}
+		b.WriteByte('{')
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, err := r.readString()
+			if err != nil {
+				return err
+			}
+			quoted, _ := json.Marshal(key)
+			b.Write(quoted)
+			b.WriteByte(':')
+			if err := r.readJSON(b, depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return fmt.Errorf("MessagePack type 0x%02x has no JSON form", c)
+	}
+	return nil
+}
+
+// readNumber reads an integer as its decimal form.
+func (r *msgpackReader) readNumber() (string, error) {
+	c, err := r.next()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return strconv.Itoa(int(c)), nil
+	case c >= 0xe0:
+		return strconv.Itoa(int(int8(c))), nil
+	case c >= 0xcc && c <= 0xcf:
+		n, err := r.readBig(1 << (c - 0xcc))
+		return strconv.FormatUint(n, 10), err
+	case c >= 0xd0 && c <= 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := r.readBig(size)
+		// sign extend from the size read
+		shift := uint(64 - 8*size)
+		return strconv.FormatInt(int64(n<<shift)>>shift, 10), err
+	}
+	return "", fmt.Errorf("MessagePack type 0x%02x is not an integer", c)
+}
+
+func (r *msgpackReader) readUint() (uint64, error) {
+	s, err := r.readNumber()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func (r *msgpackReader) readFloat() (float64, error) {
+	c, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	if c == 0xca {
+		n, err := r.readBig(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	}
+	n, err := r.readBig(8)
+	return math.Float64frombits(n), err
+}
+
+func (r *msgpackReader) readString() (string, error) {
+	c, err := r.next()
+	if err != nil {
+		return "", err
+	}
+
+	var n uint64
+	switch {
+	case c >= 0xa0 && c <= 0xbf:
+		n = uint64(c & 0x1f)
+	case c >= 0xd9 && c <= 0xdb:
+		if n, err = r.readBig(1 << (c - 0xd9)); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("MessagePack type 0x%02x is not a string", c)
+	}
+
+	s, err := r.read(n)
+	return string(s), err
+}
+
+func (r *msgpackReader) readArrayLen() (int, error) {
+	return r.readCollection(0x90, 0xdc, 0xdd)
+}
+
+func (r *msgpackReader) readMapLen() (int, error) {
+	return r.readCollection(0x80, 0xde, 0xdf)
+}
+
+func (r *msgpackReader) readCollection(fix, b16, b32 byte) (int, error) {
+	c, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+
+	var n uint64
+	switch c {
+	case b16:
+		n, err = r.readBig(2)
+	case b32:
+		n, err = r.readBig(4)
+	default:
+		if c&0xf0 != fix {
+			return 0, fmt.Errorf("MessagePack type 0x%02x is not a collection", c)
+		}
+		n = uint64(c & 0x0f)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// every element takes a byte at least
+	if n > uint64(len(r.data)-r.pos) {
+		return 0, errMsgpackShort
+	}
+	return int(n), nil
+}
+
+func (r *msgpackReader) readBig(size int) (uint64, error) {
+	b, err := r.read(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (r *msgpackReader) peek() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errMsgpackShort
+	}
+	return r.data[r.pos], nil
+}
+
+func (r *msgpackReader) next() (byte, error) {
+	c, err := r.peek()
+	if err == nil {
+		r.pos++
+	}
+	return c, err
+}
+
+func (r *msgpackReader) read(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func isMsgpackString(c byte) bool {
+	return (c >= 0xa0 && c <= 0xbf) || (c >= 0xd9 && c <= 0xdb)
+}
+
+func isMsgpackArray(c byte) bool {
+	return (c >= 0x90 && c <= 0x9f) || c == 0xdc || c == 0xdd
+}
+
+func isMsgpackMap(c byte) bool {
+	return (c >= 0x80 && c <= 0x8f) || c == 0xde || c == 0xdf
+}
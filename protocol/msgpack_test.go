@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	tests := []struct {
+		name   string
+		frames []Frame
+	}{
+		{"header only", []Frame{{Header: "ACK"}}},
+		{"id and seq", []Frame{{Header: "GAMES", Id: "r1", Seq: 1 << 40, Body: []byte(`[]`)}}},
+		{"nested body", []Frame{{Header: "START_WAIT", Seq: 3, Body: []byte(`{"gameId":"g","players":[{"name":"Zoë \"z\"","score":-20,"ready":true}],"ratio":1.5,"current":null}`)}}},
+		{"keys keep their order", []Frame{{Header: "GAMEPLAY", Body: []byte(`{"z":1,"a":2,"m":{"y":3,"b":4}}`)}}},
+		{"long values", []Frame{{Header: "ANNOUNCEMENT", Body: []byte(`{"message":"` + long + `","n":4294967296}`)}}},
+		{"batch", []Frame{
+			{Header: "BUZZED", Seq: 7, Body: []byte(`{"playerId":"a","delay":120}`)},
+			{Header: "PLAYER_SELECTED", Seq: 8, Body: []byte(`{"game":{}}`)},
+		}},
+	}
+
+	f, ok := FramingFor(FramingMsgpack)
+	if !ok {
+		t.Fatal("No msgpack framing")
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := f.Encode(tt.frames)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			got, err := f.Decode(msg)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if len(got) != len(tt.frames) {
+				t.Fatalf("got %d frames, want %d", len(got), len(tt.frames))
+			}
+			for i, want := range tt.frames {
+				g := got[i]
+				if g.Header != want.Header || g.Id != want.Id || g.Seq != want.Seq {
+					t.Errorf("frame %d is %s/%s/%d, want %s/%s/%d", i, g.Header, g.Id, g.Seq, want.Header, want.Id, want.Seq)
+				}
+				if !bytes.Equal(compact(t, g.Body), compact(t, want.Body)) {
+					t.Errorf("frame %d body is %s, want %s", i, g.Body, want.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestMsgpackDecodeInvalid(t *testing.T) {
+	f, _ := FramingFor(FramingMsgpack)
+
+	tests := map[string][]byte{
+		"empty":       {},
+		"cut short":   {0x81, 0xa4, 't', 'y'},
+		"not a map":   {0xa3, 'a', 'c', 'k'},
+		"data after":  {0x81, 0xa4, 't', 'y', 'p', 'e', 0xa3, 'A', 'C', 'K', 0xc0},
+		"short array": {0x92, 0x81, 0xa4, 't', 'y', 'p', 'e', 0xa3, 'A', 'C', 'K'},
+	}
+	for name, msg := range tests {
+		if frames, err := f.Decode(msg); err == nil {
+			t.Errorf("%s: decoded %v, want an error", name, frames)
+		}
+	}
+}
+
+func compact(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	if len(body) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, body); err != nil {
+		t.Fatalf("bad JSON %s: %v", body, err)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,124 @@
+// Package protocol describes the messages exchanged over a gogo-sockets
+// connection, for the server and any Go client.
+//
+// Every message is a frame: a 32 byte header followed by a JSON body. The
+// header holds the message type, left aligned and space padded in the
+// first 20 bytes, then blanks. Clients with the seq capability find the
+// sequence id of the frames sent by the server right aligned in the last
+// 12 bytes instead, the other framings always carry it.
+//
+// A connection opens with a HELO from the client, saying which version of
+// the protocol it speaks. From version 2 the server answers with WELCOME.
+// It then sends either GAMES, the lobby, or RESUME when the client picks a
+// seat back up.
+// Every request may carry a requestId, which is echoed in the direct reply
+// or in an ERROR or ACK.
+package protocol
+
+const (
+	// Length of the header in front of every body.
+	HeaderLen = 32
+
+	// The message type takes the start of the header, the sequence id the
+	// rest.
+	HeaderTypeLen = 20
+)
+
+// Protocol versions. A HELO without a protocolVersion is version 1, the
+// server answers with the version both sides speak.
+const (
+	// The original protocol.
+	Version1 = 1
+
+	// The server answers HELO with WELCOME, and expired buzzes are
+	// flagged instead of sent with a delay of BuzzExpired.
+	Version2 = 2
+
+	// The oldest and newest versions the server speaks.
+	MinVersion = Version1
+	Version    = Version2
+)
+
+// Capabilities, optional features listed in HELO and WELCOME.
+const (
+	// Several frames in one websocket message, separated by newlines.
+	// Version 1 clients always get them this way.
+	CapBatch = "batch"
+
+	// Resuming a dropped connection with lastSeq.
+	CapResume = "resume"
+
+	// Replies and retries matched up by requestId.
+	CapRequestId = "requestId"
+
+	// Watching games with GAME_REQ SPECTATE.
+	CapSpectate = "spectate"
+
+	// Sequence ids in the header of legacy frames. Version 1 clients do
+	// not get them, older clients read the whole header as the type.
+	CapSeq = "seq"
+)
+
+// Headers of the messages clients send.
+const (
+	HeaderHello     = "HELO"
+	HeaderInit      = "INIT"
+	HeaderGameReq   = "GAME_REQ"
+	HeaderBeginGame = "BEGIN_GAME"
+	HeaderNextRound = "NEXT_ROUND"
+	HeaderGameplay  = "GAMEPLAY"
+)
+
+// GAME_REQ actions.
+const (
+	ActionCreate     = "CREATE"
+	ActionJoin       = "JOIN"
+	ActionLeave      = "LEAVE"
+	ActionSpectate   = "SPECTATE"
+	ActionUnspectate = "UNSPECTATE"
+)
+
+// GAMEPLAY requests.
+const (
+	RequestWheelSpin      = "WHEEL_SPIN"
+	RequestQuestionSelect = "QUESTION_SELECT"
+	RequestBuzz           = "BUZZ"
+	RequestAnswer         = "ANSWER"
+)
+
+// Headers of the messages the server sends.
+const (
+	HeaderWelcome            = "WELCOME"
+	HeaderGames              = "GAMES"
+	HeaderStartWait          = "START_WAIT"
+	HeaderStartRound         = "START_ROUND"
+	HeaderSpectating         = "SPECTATING"
+	HeaderWheelSpun          = "WHEEL_SPUN"
+	HeaderQuestionResponse   = "QUESTION_RESPONSE"
+	HeaderBuzzed             = "BUZZED"
+	HeaderPlayerSelected     = "PLAYER_SELECTED"
+	HeaderAnswerResponse     = "ANSWER_RESPONSE"
+	HeaderResume             = "RESUME"
+	HeaderPlayerDisconnected = "PLAYER_DISCONNECTED"
+	HeaderPlayerReconnected  = "PLAYER_RECONNECTED"
+	HeaderPlayerLeft         = "PLAYER_LEFT"
+	HeaderGameEnded          = "GAME_ENDED"
+	HeaderAnnouncement       = "ANNOUNCEMENT"
+	HeaderServerShutdown     = "SERVER_SHUTDOWN"
+	HeaderError              = "ERROR"
+	HeaderAck                = "ACK"
+)
+
+// Codes of ERROR frames for messages the server could not make sense of.
+// The game has its own codes for moves that are not allowed, see
+// game.ErrorCode.
+const (
+	CodeBadLength      = "BAD_LENGTH"
+	CodeBadBody        = "BAD_BODY"
+	CodeUnknownHeader  = "UNKNOWN_HEADER"
+	CodeUnknownRequest = "UNKNOWN_REQUEST"
+	CodeRateLimited    = "RATE_LIMITED"
+)
+
+// A buzz with this delay means the player's time ran out.
+const BuzzExpired = 1 << 16
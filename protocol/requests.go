@@ -0,0 +1,108 @@
+package protocol
+
+// Meta is read from every request before it is routed.
+type Meta struct {
+	// Optional id, echoed in the reply. Retries with the same id of
+	// CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+	// twice.
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// Hello is the body of HELO, the first message on every connection. Which
+// credentials are needed depends on how the server authenticates.
+type Hello struct {
+	Key      string `json:"key,omitempty"`
+	ClientId string `json:"clientId,omitempty"`
+
+	// Signed token binding the clientId, for token authentication.
+	Token string `json:"token,omitempty"`
+
+	// Tenant the key belongs to, for per-tenant keys.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Sequence id of the last frame the client got, if it is reconnecting.
+	LastSeq uint64 `json:"lastSeq,omitempty"`
+
+	// The newest protocol version the client speaks, 0 for version 1.
+	ProtocolVersion int `json:"protocolVersion,omitempty"`
+
+	// The capabilities the client supports.
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// The framing the client wants for the rest of the connection, from
+	// version 2. Legacy if empty or unknown to the server.
+	Framing string `json:"framing,omitempty"`
+}
+
+// GameRequest is the body of GAME_REQ. Which fields are used depends on
+// the action.
+type GameRequest struct {
+	Meta
+	Action string `json:"action"`
+
+	// The game to JOIN, LEAVE, SPECTATE or UNSPECTATE.
+	GameId string `json:"gameId,omitempty"`
+
+	// The player's name, for CREATE and JOIN.
+	Name string `json:"name,omitempty"`
+
+	// The shape of the game, for CREATE.
+	NumCategories        uint8 `json:"numCategories,omitempty"`
+	QuestionsPerCategory uint8 `json:"questionsPerCategory,omitempty"`
+	TotalQuestions       uint8 `json:"totalQuestions,omitempty"`
+}
+
+// BeginGame is the body of BEGIN_GAME, which starts the first round.
+type BeginGame struct {
+	Meta
+	GameId        string `json:"gameId"`
+	QuestionCount uint8  `json:"questionCount"`
+}
+
+// NextRound is the body of NEXT_ROUND.
+type NextRound struct {
+	Meta
+	GameId string `json:"gameId"`
+}
+
+// Gameplay is the part every GAMEPLAY body shares.
+type Gameplay struct {
+	Meta
+	Request string `json:"request"`
+	GameId  string `json:"gameId"`
+}
+
+// WheelSpin is the GAMEPLAY WHEEL_SPIN request, from the current player.
+type WheelSpin struct {
+	Gameplay
+	SpinFactor int `json:"spinFactor"`
+}
+
+// QuestionSelect is the GAMEPLAY QUESTION_SELECT request, from the
+// current player.
+type QuestionSelect struct {
+	Gameplay
+	Category   string `json:"category"`
+	PointValue uint8  `json:"pointValue"`
+}
+
+// Buzz is the GAMEPLAY BUZZ request. Every player buzzes for every
+// question, with a delay of BuzzExpired if their time ran out.
+type Buzz struct {
+	Gameplay
+
+	// Milliseconds from the question being shown to the buzz.
+	Delay uint32 `json:"delay"`
+
+	// Set instead of the BuzzExpired delay when the player's time ran
+	// out, from version 2.
+	Expired bool `json:"expired,omitempty"`
+}
+
+// Answer is the GAMEPLAY ANSWER request, from the player who won the buzz.
+type Answer struct {
+	Gameplay
+
+	// Index into the question's choices.
+	AnswerIndex uint8 `json:"index"`
+}
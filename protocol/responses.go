@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"gogo-sockets/game"
+)
+
+// GAMES is a []*game.Game, START_WAIT, START_ROUND and SPECTATING a
+// *game.Game. The other bodies the server sends follow.
+
+// Welcome is the body of WELCOME, the answer to the HELO of clients
+// speaking version 2 or later. It comes before any other frame.
+type Welcome struct {
+	// The version both sides speak, the lower of the client's and the
+	// server's newest.
+	ProtocolVersion int `json:"protocolVersion"`
+
+	// Everything the server supports, not just what the client asked for.
+	Capabilities []string `json:"capabilities"`
+
+	// The framing of the rest of the connection, this frame included.
+	Framing string `json:"framing"`
+
+	// The clientId the server knows the client by.
+	ClientId string `json:"clientId"`
+}
+
+// Error is the body of ERROR.
+type Error struct {
+	// Stable, machine readable, see the Code constants and game.ErrorCode.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// The requestId of the message that caused the error, if it had one.
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// Ack is the body of ACK, the reply to a request with a requestId that
+// got no other direct reply. The requestId is added to it.
+type Ack struct{}
+
+// WheelSpun is the body of WHEEL_SPUN.
+type WheelSpun struct {
+	PlayerId   string `json:"playerId"`
+	SpinFactor int    `json:"spinFactor"`
+}
+
+// QuestionResponse is the body of QUESTION_RESPONSE, the selected question.
+type QuestionResponse struct {
+	Question *game.Question `json:"question"`
+	Game     *game.Game     `json:"game"`
+}
+
+// Buzzed is the body of BUZZED, someone buzzed in time.
+type Buzzed struct {
+	PlayerId string `json:"playerId"`
+	Delay    uint32 `json:"delay"`
+}
+
+// PlayerSelected is the body of PLAYER_SELECTED, the game's current
+// player won the buzz and answers.
+type PlayerSelected struct {
+	Game *game.Game `json:"game"`
+}
+
+// AnswerResponse is the body of ANSWER_RESPONSE.
+type AnswerResponse struct {
+	Correct       bool       `json:"correct"`
+	CorrectAnswer int        `json:"correctAnswer"`
+	Game          *game.Game `json:"game"`
+}
+
+// Resume is the body of RESUME, the state of the game a reconnecting
+// client has a seat in, when the frames it missed could not be replayed.
+type Resume struct {
+	Game *game.Game `json:"game"`
+
+	// The question being played, nil between questions.
+	Question *game.Question `json:"question"`
+}
+
+// SeatEvent is the body of PLAYER_DISCONNECTED, PLAYER_RECONNECTED and
+// PLAYER_LEFT.
+type SeatEvent struct {
+	PlayerId string     `json:"playerId"`
+	Game     *game.Game `json:"game"`
+}
+
+// GameEnded is the body of GAME_ENDED, sent when an admin ends a game.
+type GameEnded struct {
+	Game   *game.Game `json:"game"`
+	Reason string     `json:"reason"`
+}
+
+// Announcement is the body of ANNOUNCEMENT, a message from the operators
+// to the lobby or a game.
+type Announcement struct {
+	Message string `json:"message"`
+	GameId  string `json:"gameId,omitempty"`
+}
+
+// ShutdownNotice is the body of SERVER_SHUTDOWN, sent before the server
+// closes every connection.
+type ShutdownNotice struct {
+	Message          string `json:"message"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+}
@@ -0,0 +1,406 @@
+// Code generated by go generate ./protocol. DO NOT EDIT.
+
+/** Length of the header in front of every body. */
+export const HEADER_LEN = 32;
+
+/** The message type takes the start of the header, the sequence id the rest. */
+export const HEADER_TYPE_LEN = 20;
+
+/** Meta is read from every request before it is routed. */
+export interface Meta {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+}
+
+/** Envelope is a frame in envelope framing. Type is the message header. */
+export interface Envelope {
+  type: string;
+  /** The requestId, of a request or of the request a reply answers. */
+  id?: string;
+  /** Sequence id, for frames sent by the server. */
+  seq?: number;
+  body?: unknown;
+}
+
+/**
+ * Hello is the body of HELO, the first message on every connection. Which
+ * credentials are needed depends on how the server authenticates.
+ */
+export interface Hello {
+  key?: string;
+  clientId?: string;
+  /** Signed token binding the clientId, for token authentication. */
+  token?: string;
+  /** Tenant the key belongs to, for per-tenant keys. */
+  tenant?: string;
+  /** Sequence id of the last frame the client got, if it is reconnecting. */
+  lastSeq?: number;
+  /** The newest protocol version the client speaks, 0 for version 1. */
+  protocolVersion?: number;
+  /** The capabilities the client supports. */
+  capabilities?: string[];
+  /**
+   * The framing the client wants for the rest of the connection, from
+   * version 2. Legacy if empty or unknown to the server.
+   */
+  framing?: string;
+}
+
+/**
+ * GameRequest is the body of GAME_REQ. Which fields are used depends on
+ * the action.
+ */
+export interface GameRequest {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  action: string;
+  /** The game to JOIN, LEAVE, SPECTATE or UNSPECTATE. */
+  gameId?: string;
+  /** The player's name, for CREATE and JOIN. */
+  name?: string;
+  /** The shape of the game, for CREATE. */
+  numCategories?: number;
+  questionsPerCategory?: number;
+  totalQuestions?: number;
+}
+
+/** BeginGame is the body of BEGIN_GAME, which starts the first round. */
+export interface BeginGame {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  gameId: string;
+  questionCount: number;
+}
+
+/** NextRound is the body of NEXT_ROUND. */
+export interface NextRound {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  gameId: string;
+}
+
+/** WheelSpin is the GAMEPLAY WHEEL_SPIN request, from the current player. */
+export interface WheelSpin {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  request: string;
+  gameId: string;
+  spinFactor: number;
+}
+
+/**
+ * QuestionSelect is the GAMEPLAY QUESTION_SELECT request, from the
+ * current player.
+ */
+export interface QuestionSelect {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  request: string;
+  gameId: string;
+  category: string;
+  pointValue: number;
+}
+
+/**
+ * Buzz is the GAMEPLAY BUZZ request. Every player buzzes for every
+ * question, with a delay of BuzzExpired if their time ran out.
+ */
+export interface Buzz {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  request: string;
+  gameId: string;
+  /** Milliseconds from the question being shown to the buzz. */
+  delay: number;
+  /**
+   * Set instead of the BuzzExpired delay when the player's time ran
+   * out, from version 2.
+   */
+  expired?: boolean;
+}
+
+/** Answer is the GAMEPLAY ANSWER request, from the player who won the buzz. */
+export interface Answer {
+  /**
+   * Optional id, echoed in the reply. Retries with the same id of
+   * CREATE, JOIN, BUZZ and ANSWER are answered again rather than run
+   * twice.
+   */
+  requestId?: string;
+  request: string;
+  gameId: string;
+  /** Index into the question's choices. */
+  index: number;
+}
+
+/**
+ * Welcome is the body of WELCOME, the answer to the HELO of clients
+ * speaking version 2 or later. It comes before any other frame.
+ */
+export interface Welcome {
+  /**
+   * The version both sides speak, the lower of the client's and the
+   * server's newest.
+   */
+  protocolVersion: number;
+  /** Everything the server supports, not just what the client asked for. */
+  capabilities: string[];
+  /** The framing of the rest of the connection, this frame included. */
+  framing: string;
+  /** The clientId the server knows the client by. */
+  clientId: string;
+}
+
+/** A game, as every client sees it. */
+export interface Game {
+  gameId: string;
+  gameState: GameState;
+  /** by convention, first player is host */
+  players: Player[];
+  categories: string[];
+  remainingQuestions: number;
+  currentPlayerId: string;
+}
+
+/** Where a game is at, sent as its number. */
+export enum GameState {
+  WAITING = 0,
+  UNKNOWN = 1,
+  STARTED = 2,
+  ENDED = 3,
+  SPIN = 4,
+  QUESTION = 5,
+}
+
+/** A seat in a game, as every client sees it. */
+export interface Player {
+  playerId: string;
+  name: string;
+  /**
+   * wrong answers cost their points, so it can go negative: with 6 categories it
+   * is within 6*(10*(5+4+...+1)) = +/-900 -> int16
+   */
+  score: number;
+  /** is this player is the current player? */
+  CurrentPlayer: boolean;
+  /** dropped, seat held until they come back or time out */
+  disconnected: boolean;
+}
+
+/** WheelSpun is the body of WHEEL_SPUN. */
+export interface WheelSpun {
+  playerId: string;
+  spinFactor: number;
+}
+
+/** QuestionResponse is the body of QUESTION_RESPONSE, the selected question. */
+export interface QuestionResponse {
+  question: Question | null;
+  game: Game | null;
+}
+
+/** A question as the players see it, without the answer. */
+export interface Question {
+  category: string;
+  pointValue: number;
+  text: string;
+  choices: string[];
+}
+
+/** Buzzed is the body of BUZZED, someone buzzed in time. */
+export interface Buzzed {
+  playerId: string;
+  delay: number;
+}
+
+/**
+ * PlayerSelected is the body of PLAYER_SELECTED, the game's current
+ * player won the buzz and answers.
+ */
+export interface PlayerSelected {
+  game: Game | null;
+}
+
+/** AnswerResponse is the body of ANSWER_RESPONSE. */
+export interface AnswerResponse {
+  correct: boolean;
+  correctAnswer: number;
+  game: Game | null;
+}
+
+/**
+ * Resume is the body of RESUME, the state of the game a reconnecting
+ * client has a seat in, when the frames it missed could not be replayed.
+ */
+export interface Resume {
+  game: Game | null;
+  /** The question being played, nil between questions. */
+  question: Question | null;
+}
+
+/**
+ * SeatEvent is the body of PLAYER_DISCONNECTED, PLAYER_RECONNECTED and
+ * PLAYER_LEFT.
+ */
+export interface SeatEvent {
+  playerId: string;
+  game: Game | null;
+}
+
+/** GameEnded is the body of GAME_ENDED, sent when an admin ends a game. */
+export interface GameEnded {
+  game: Game | null;
+  reason: string;
+}
+
+/**
+ * Announcement is the body of ANNOUNCEMENT, a message from the operators
+ * to the lobby or a game.
+ */
+export interface Announcement {
+  message: string;
+  gameId?: string;
+}
+
+/**
+ * ShutdownNotice is the body of SERVER_SHUTDOWN, sent before the server
+ * closes every connection.
+ */
+export interface ShutdownNotice {
+  message: string;
+  reconnectAfterMs: number;
+}
+
+/** Error is the body of ERROR. */
+export interface Error {
+  /** Stable, machine readable, see the Code constants and game.ErrorCode. */
+  code: string;
+  message: string;
+  /** The requestId of the message that caused the error, if it had one. */
+  requestId?: string;
+}
+
+/**
+ * Ack is the body of ACK, the reply to a request with a requestId that
+ * got no other direct reply. The requestId is added to it.
+ */
+export interface Ack {
+}
+
+/** Bodies of the messages clients send, by header, or header/request. */
+export interface ClientMessages {
+  /** First message on every connection, anything else closes it. */
+  "HELO": Hello;
+  /** Asks for the lobby, the reply is GAMES. The server does this itself after HELO. */
+  "INIT": null;
+  /** Creates a game with the sender as its host, the reply is START_WAIT. */
+  "GAME_REQ/CREATE": GameRequest & { action: "CREATE" };
+  /** Takes a seat in a waiting game. The players get START_WAIT, or START_ROUND for the third player. */
+  "GAME_REQ/JOIN": GameRequest & { action: "JOIN" };
+  /** Gives up a seat and goes back to the lobby. */
+  "GAME_REQ/LEAVE": GameRequest & { action: "LEAVE" };
+  /** Watches a game without a seat, the reply is SPECTATING. */
+  "GAME_REQ/SPECTATE": GameRequest & { action: "SPECTATE" };
+  /** Stops watching a game, the reply is GAMES. */
+  "GAME_REQ/UNSPECTATE": GameRequest & { action: "UNSPECTATE" };
+  /** Sets the number of questions and starts the first round. */
+  "BEGIN_GAME": BeginGame;
+  /** Starts the next round. */
+  "NEXT_ROUND": NextRound;
+  /** The current player spun the wheel. */
+  "GAMEPLAY/WHEEL_SPIN": WheelSpin & { request: "WHEEL_SPIN" };
+  /** The current player picked a question. */
+  "GAMEPLAY/QUESTION_SELECT": QuestionSelect & { request: "QUESTION_SELECT" };
+  /** A player buzzed, or their time ran out. */
+  "GAMEPLAY/BUZZ": Buzz & { request: "BUZZ" };
+  /** The player who won the buzz answered. */
+  "GAMEPLAY/ANSWER": Answer & { request: "ANSWER" };
+}
+
+/** Bodies of the messages the server sends, by header. */
+export interface ServerMessages {
+  /**
+   * Welcome is the body of WELCOME, the answer to the HELO of clients
+   * speaking version 2 or later. It comes before any other frame.
+   */
+  "WELCOME": Welcome;
+  /** Every game. Sent to the lobby whenever a game changes. */
+  "GAMES": Game[];
+  /** The game is waiting for players. */
+  "START_WAIT": Game & Meta;
+  /** A round started. */
+  "START_ROUND": Game;
+  /** The game the client is now watching. */
+  "SPECTATING": Game & Meta;
+  /** WheelSpun is the body of WHEEL_SPUN. */
+  "WHEEL_SPUN": WheelSpun;
+  /** QuestionResponse is the body of QUESTION_RESPONSE, the selected question. */
+  "QUESTION_RESPONSE": QuestionResponse;
+  /** Buzzed is the body of BUZZED, someone buzzed in time. */
+  "BUZZED": Buzzed;
+  /**
+   * PlayerSelected is the body of PLAYER_SELECTED, the game's current
+   * player won the buzz and answers.
+   */
+  "PLAYER_SELECTED": PlayerSelected;
+  /** AnswerResponse is the body of ANSWER_RESPONSE. */
+  "ANSWER_RESPONSE": AnswerResponse;
+  /**
+   * Resume is the body of RESUME, the state of the game a reconnecting
+   * client has a seat in, when the frames it missed could not be replayed.
+   */
+  "RESUME": Resume;
+  /** A player dropped, their seat is held for a while. */
+  "PLAYER_DISCONNECTED": SeatEvent;
+  /** A dropped player is back. */
+  "PLAYER_RECONNECTED": SeatEvent;
+  /** A dropped player did not come back in time and lost their seat. */
+  "PLAYER_LEFT": SeatEvent;
+  /** GameEnded is the body of GAME_ENDED, sent when an admin ends a game. */
+  "GAME_ENDED": GameEnded;
+  /**
+   * Announcement is the body of ANNOUNCEMENT, a message from the operators
+   * to the lobby or a game.
+   */
+  "ANNOUNCEMENT": Announcement;
+  /**
+   * ShutdownNotice is the body of SERVER_SHUTDOWN, sent before the server
+   * closes every connection.
+   */
+  "SERVER_SHUTDOWN": ShutdownNotice;
+  /** Error is the body of ERROR. */
+  "ERROR": Error;
+  /**
+   * Ack is the body of ACK, the reply to a request with a requestId that
+   * got no other direct reply. The requestId is added to it.
+   */
+  "ACK": Ack & Meta;
+}
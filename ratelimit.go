@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gogo-sockets/protocol"
+)
+
+// Code for messages refused by the rate limits.
+const errRateLimited = protocol.CodeRateLimited
+
+// Close code for clients that kept going over their rate limits.
+const closeAbuse = 4005
+
+// budget allows rate messages a second, in bursts of up to burst.
+type budget struct {
+	rate  float64
+	burst int
+}
+
+// rateLimits are the message budgets of every client, over all its
+// messages and by header, and how many refused messages get a client
+// disconnected.
+type rateLimits struct {
+	// The budget of all the messages of a client, whatever their header.
+	total budget
+
+	// The budget of headers without their own. Unknown headers and
+	// messages that could not be read share one.
+	defaults budget
+	headers  map[string]budget
+
+	// A client is disconnected once more than strikes of its messages
+	// are refused within about window. 0 never disconnects.
+	strikes int
+	window  time.Duration
+}
+
+func newRateLimits(cfg *Config) *rateLimits {
+	return &rateLimits{
+		total:    budget{rate: cfg.ClientMsgRate, burst: cfg.ClientMsgBurst},
+		defaults: budget{rate: cfg.MsgRate, burst: cfg.MsgBurst},
+		headers:  cfg.msgLimits,
+		strikes:  cfg.AbuseStrikes,
+		window:   cfg.AbuseWindow,
+	}
+}
+
+func (l *rateLimits) budget(header string) budget {
+	if b, ok := l.headers[header]; ok {
+		return b
+	}
+	return l.defaults
+}
+
+// parseRateLimits reads budgets like "GAME_REQ=1/5,GAMEPLAY=10/20", in
+// messages a second and burst, for the headers clients send.
+func parseRateLimits(s string) (map[string]budget, error) {
+	known := map[string]bool{}
+	for _, m := range protocol.Messages {
+		if m.Direction == protocol.FromClient {
+			known[m.Header] = true
+		}
+	}
+
+	budgets := map[string]budget{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Expected header=rate/burst, got %q", part)
+		}
+		if !known[kv[0]] {
+			return nil, fmt.Errorf("Unknown message header %q", kv[0])
+		}
+
+		rb := strings.SplitN(kv[1], "/", 2)
+		if len(rb) != 2 {
+			return nil, fmt.Errorf("Expected rate/burst, got %q", kv[1])
+		}
+		rate, err := strconv.ParseFloat(rb[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("Invalid rate %q for %s", rb[0], kv[0])
+		}
+		burst, err := strconv.Atoi(rb[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("Invalid burst %q for %s", rb[1], kv[0])
+		}
+
+		budgets[kv[0]] = budget{rate: rate, burst: burst}
+	}
+
+	return budgets, nil
+}
+
+// tokenBucket takes messages out of a budget.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(rate float64, burst int, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// checkRate takes a message with the header out of the client's budgets,
+// the one for all its messages first, then the one for the header.
+// Refused messages get a RATE_LIMITED error, and once there are too many
+// the client is disconnected. Only called from the readPump.
+func (c *Client) checkRate(header, requestId string) bool {
+	if !messages.Knows(header) {
+		header = ""
+	}
+
+	if c.buckets == nil {
+		c.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := c.buckets[header]
+	if !ok {
+		b = &tokenBucket{}
+		c.buckets[header] = b
+	}
+
+	label := header
+	if label == "" {
+		label = "other"
+	}
+
+	now := time.Now()
+	limits := c.Hub.limits
+	total, budget := limits.total, limits.budget(header)
+	if !c.total.allow(total.rate, total.burst, now) {
+		label = "all"
+	} else if b.allow(budget.rate, budget.burst, now) {
+		return true
+	}
+	rateLimited.inc(label)
+
+	if limits.strikes > 0 && !c.strikes.allow(float64(limits.strikes)/limits.window.Seconds(), limits.strikes, now) {
+		log.Printf("Disconnecting client %s, too many messages over the rate limits", c.ClientId)
+		abuseDisconnects.inc("")
+		c.abusive = true
+		c.closeWith(closeAbuse, "Too many messages")
+		return false
+	}
+
+	if label == "all" {
+		SendError(c, requestId, newClientError(errRateLimited, "Too many messages, slow down"))
+		return false
+	}
+	SendError(c, requestId, newClientError(errRateLimited, "Too many %s messages, slow down", label))
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	start := time.Now()
+	var b tokenBucket
+
+	// a full burst, then nothing until tokens come back
+	for i := 0; i < 3; i++ {
+		if !b.allow(2, 3, start) {
+			t.Fatalf("message %d of the burst refused", i)
+		}
+	}
+	if b.allow(2, 3, start) {
+		t.Fatal("message past the burst allowed")
+	}
+
+	// 2 a second, one is back after half a second
+	if !b.allow(2, 3, start.Add(500*time.Millisecond)) {
+		t.Fatal("message refused once a token came back")
+	}
+	if b.allow(2, 3, start.Add(500*time.Millisecond)) {
+		t.Fatal("message allowed with no token left")
+	}
+
+	// a long pause never gives more than the burst
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !b.allow(2, 3, later) {
+			t.Fatalf("message %d of the burst after a pause refused", i)
+		}
+	}
+	if b.allow(2, 3, later) {
+		t.Fatal("message past the burst allowed after a pause")
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]budget
+		err  bool
+	}{
+		{in: "", want: map[string]budget{}},
+		{in: "GAME_REQ=1/5", want: map[string]budget{"GAME_REQ": {rate: 1, burst: 5}}},
+		{in: " GAME_REQ=0.5/2 , GAMEPLAY=10/20 ", want: map[string]budget{"GAME_REQ": {rate: 0.5, burst: 2}, "GAMEPLAY": {rate: 10, burst: 20}}},
+		{in: "GAME_REQ", err: true},
+		{in: "GAME_REQ=1", err: true},
+		{in: "GAME_REQ=0/5", err: true},
+		{in: "GAME_REQ=1/0", err: true},
+		{in: "GAME_REQ=x/5", err: true},
+		{in: "START_ROUND=1/5", err: true},
+		{in: "NOPE=1/5", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRateLimits(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseRateLimits(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRateLimits(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseRateLimits(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for header, b := range tt.want {
+			if got[header] != b {
+				t.Errorf("parseRateLimits(%q)[%s] = %v, want %v", tt.in, header, got[header], b)
+			}
+		}
+	}
+}
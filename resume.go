@@ -0,0 +1,227 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// seats holds on to the game seats of players whose socket dropped, keyed
+// by clientId, until they come back or their grace window runs out.
+type seats struct {
+	mu   sync.Mutex
+	held map[string]*seat
+}
+
+// seat is what is kept of a dropped player while they are away: the
+// detached client, which still records the frames sent to its rooms.
+type seat struct {
+	timer  *time.Timer
+	client *Client
+
+	// When the grace window runs out.
+	deadline time.Time
+}
+
+var heldSeats = &seats{held: make(map[string]*seat)}
+
+// hold keeps the seat of the detached client until the deadline, then
+// calls release.
+func (s *seats) hold(client *Client, deadline time.Time, release func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holdLocked(client, deadline, release)
+}
+
+// drop marks the player of the detached client as disconnected and holds
+// their seat, unless the client has connected again in the meantime, or
+// has no seat left. Returns the player's game, nil if no seat is held.
+func (s *seats) drop(client *Client, release func()) *game.Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a reconnect that beat us here found no seat to claim, and resumed
+	// the player without one. The hub never takes the lock, asking it
+	// under the lock is safe.
+	if client.Hub.Connected(client.ClientId) {
+		return nil
+	}
+
+	g := game.SetPlayerConnected(client.ClientId, false)
+	if g == nil {
+		return nil
+	}
+	s.holdLocked(client, time.Now().Add(client.Hub.cfg.SeatGrace), release)
+	return g
+}
+
+func (s *seats) holdLocked(client *Client, deadline time.Time, release func()) {
+	clientId := client.ClientId
+	if old, ok := s.held[clientId]; ok {
+		old.timer.Stop()
+	}
+
+	st := &seat{client: client, deadline: deadline}
+	st.timer = time.AfterFunc(time.Until(deadline), func() {
+		// released under the lock so a reconnect either wins the seat
+		// back or waits until the player is fully gone
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.held[clientId] != st {
+			return // claimed in the meantime
+		}
+		delete(s.held, clientId)
+		release()
+	})
+	s.held[clientId] = st
+}
+
+// claim stops the grace window for the client, if one is running, and
+// returns the detached client of the dropped connection along with the
+// deadline of its window. Returns nil if no seat is held for the client.
+func (s *seats) claim(clientId string) (*Client, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.held[clientId]
+	if !ok {
+		return nil, time.Time{}
+	}
+
+	st.timer.Stop()
+	delete(s.held, clientId)
+	return st.client, st.deadline
+}
+
+// isHeld says if a seat is held for the client, leaving its grace window
+// running.
+func (s *seats) isHeld(clientId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.held[clientId]
+	return ok
+}
+
+// dropSeat is called once the socket of a seated player is gone and the
+// client has been detached. The player is only marked as disconnected, the
+// seat is released once the grace window runs out.
+func dropSeat(client *Client) {
+	hub, clientId := client.Hub, client.ClientId
+
+	g := heldSeats.drop(client, func() {
+		releaseSeat(client)
+	})
+	if g == nil {
+		hub.unregister <- client
+		return
+	}
+
+	err := MarshalAndSendToGame(hub, g, "PLAYER_DISCONNECTED", protocol.SeatEvent{PlayerId: clientId, Game: g})
+	if err != nil {
+		log.Println("Could not send PLAYER_DISCONNECTED: ", err)
+	}
+}
+
+// holdSeat gives a claimed seat back to the detached client. The window
+// still ends at the deadline it was claimed with, a client retrying over
+// and over cannot keep the seat past it.
+func holdSeat(client *Client, deadline time.Time) {
+	heldSeats.hold(client, deadline, func() {
+		releaseSeat(client)
+	})
+}
+
+// releaseSeat takes the player out of their game, and the detached client
+// out of its rooms, for good.
+func releaseSeat(client *Client) {
+	hub := client.Hub
+	hub.unregister <- client
+
+	// back on a new socket without having claimed the seat, see seats.drop
+	if hub.Connected(client.ClientId) {
+		return
+	}
+	releasePlayer(hub, client.ClientId)
+}
+
+// releaseRestoredSeats releases the players of games restored from a
+// snapshot that did not come back within the grace window. They have no
+// client to hold their seat.
+func releaseRestoredSeats(hub *Hub, gls []*game.Game) {
+	time.Sleep(hub.cfg.SeatGrace)
+
+	for _, g := range gls {
+		for _, p := range g.Players {
+			// players who came back and dropped again have their own
+			// grace window
+			if p.Disconnected && !heldSeats.isHeld(p.PlayerId) {
+				releasePlayer(hub, p.PlayerId)
+			}
+		}
+	}
+}
+
+// releasePlayer takes the player out of their game.
+func releasePlayer(hub *Hub, clientId string) {
+	g, remove := game.RemovePlayer(clientId)
+	if g == nil {
+		return
+	}
+
+	if !remove {
+		err := MarshalAndSendToGame(hub, g, "PLAYER_LEFT", protocol.SeatEvent{PlayerId: clientId, Game: g})
+		if err != nil {
+			log.Println("Could not send PLAYER_LEFT: ", err)
+		}
+		return
+	}
+
+	// TODO: send the remaining players a game abandoned message
+	if err := removeGame(hub, g.GameId); err != nil {
+		log.Println("Could not send GAMES: ", err)
+	}
+}
+
+// resumeSeat reattaches a reconnecting client to the game it has a seat
+// in. Unless the hub already resent the frames it missed, the client is
+// sent the full state of the game to resync from. Returns false if the
+// client is not in a game, or cannot resume.
+func resumeSeat(client *Client) bool {
+	if !client.Can(protocol.CapResume) {
+		return false
+	}
+
+	g := game.SetPlayerConnected(client.ClientId, true)
+	if g == nil {
+		return false
+	}
+
+	client.Hub.Join(client.ClientId, gameRoom(g.GameId))
+
+	if client.caughtUp {
+		announceResume(client, g)
+		return true
+	}
+
+	err := MarshalAndSend(client, "RESUME", protocol.Resume{Game: g, Question: g.CurrentQuestion()})
+	if err != nil {
+		log.Println("Could not send RESUME: ", err)
+	}
+
+	announceResume(client, g)
+	return true
+}
+
+// announceResume tells the game the player is back.
+func announceResume(client *Client, g *game.Game) {
+	err := MarshalAndSendToGame(client.Hub, g, "PLAYER_RECONNECTED", protocol.SeatEvent{PlayerId: client.ClientId, Game: g})
+	if err != nil {
+		log.Println("Could not send PLAYER_RECONNECTED: ", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gogo-sockets/game"
+	"gogo-sockets/protocol"
+)
+
+// Peer is the client a request came from, as the handlers see it: who it
+// is, what it speaks, and where the direct replies go.
+type Peer interface {
+	Id() string
+	ProtocolVersion() int
+	Can(capability string) bool
+	Reply(requestId, header string, body interface{}) error
+}
+
+var _ Peer = (*Client)(nil)
+
+// Request is a message from a client, as the handlers see it.
+type Request struct {
+	Client Peer
+
+	// Where replies to other clients go and how the client moves between
+	// rooms, its hub.
+	Sender Sender
+
+	// The message header, and the route it was dispatched on: the header,
+	// or header/sub-request for headers split on a body field.
+	Header string
+	Route  string
+
+	// The requestId, empty if the client did not send one.
+	Id string
+
+	Body []byte
+
+	// The game the message is about, set by the inGame middleware.
+	Game *game.Game
+}
+
+// Decode unmarshals the body of the message into one of the protocol
+// types, fields the type does not have are an error.
+func (r *Request) Decode(v interface{}) error {
+	return protocol.DecodeBody(r.Body, v)
+}
+
+// Reply sends the direct reply to the request.
+func (r *Request) Reply(header string, body interface{}) error {
+	return r.Client.Reply(r.Id, header, body)
+}
+
+// Handler handles the messages of one route. A returned error is sent to
+// the client as an ERROR frame.
+type Handler func(r *Request) error
+
+// Middleware wraps a handler with more behaviour.
+type Middleware func(next Handler) Handler
+
+// Router dispatches messages to the handler registered for their route.
+type Router struct {
+	routes map[string]Handler
+
+	// Headers whose messages are routed on a body field, and how to read
+	// the field.
+	splits map[string]func(body []byte) (string, error)
+
+	middleware []Middleware
+}
+
+func newRouter() *Router {
+	return &Router{
+		routes: make(map[string]Handler),
+		splits: make(map[string]func(body []byte) (string, error)),
+	}
+}
+
+// Use adds middleware run for every route, the first added runs first.
+// Only routes added after it are wrapped.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+// Split routes the messages with the header on a body field, read by
+// field. Their routes are "header/value".
+func (rt *Router) Split(header string, field func(body []byte) (string, error)) {
+	rt.splits[header] = field
+}
+
+// Handle registers the handler for the route, wrapped in the route's own
+// middleware and then the router's.
+func (rt *Router) Handle(route string, h Handler, mw ...Middleware) {
+	if _, ok := rt.routes[route]; ok {
+		panic("route registered twice: " + route)
+	}
+
+	h = chain(h, mw)
+	h = chain(h, rt.middleware)
+	rt.routes[route] = h
+}
+
+func chain(h Handler, mw []Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Knows tells whether messages with the header have routes.
+func (rt *Router) Knows(header string) bool {
+	if _, ok := rt.splits[header]; ok {
+		return true
+	}
+
+	_, ok := rt.routes[header]
+	return ok
+}
+
+// Dispatch hands the frame to the handler of its route.
+func (rt *Router) Dispatch(client *Client, f protocol.Frame) {
+	header := f.Header
+	r := &Request{Client: client, Sender: client.Hub, Header: header, Route: header, Id: f.Id, Body: f.Body}
+	if r.Id == "" {
+		r.Id = requestIdOf(f.Body)
+	}
+
+	label := "other"
+	if rt.Knows(header) {
+		label = header
+	}
+	messagesIn.inc(label)
+
+	if field, ok := rt.splits[header]; ok {
+		sub, err := field(r.Body)
+		if err != nil {
+			SendError(client, r.Id, err)
+			return
+		}
+		r.Route = header + "/" + sub
+	}
+
+	h, ok := rt.routes[r.Route]
+	switch {
+	case ok:
+		// requests with an id get a reply, an ERROR or an ACK, if the
+		// client matches them up
+		if r.Id != "" && client.Can(protocol.CapRequestId) {
+			client.beginRequest(r.Id)
+			defer client.finishRequest()
+		}
+		if err := h(r); err != nil {
+			SendError(client, r.Id, err)
+		}
+	case r.Route != header:
+		SendError(client, r.Id, newClientError(errUnknownRequest, "Unknown %s request %q", header, r.Route[len(header)+1:]))
+	default:
+		SendError(client, r.Id, newClientError(errUnknownHeader, "Unknown message header %q", header))
+	}
+}
+
+// typed adapts a func(*Request, *T) error into a Handler, the body is
+// decoded into a new T before it is called.
+func typed(fn interface{}) Handler {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != reflect.TypeOf(&Request{}) ||
+		t.In(1).Kind() != reflect.Ptr || t.NumOut() != 1 || t.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		panic(fmt.Sprintf("typed handler must be func(*Request, *T) error, got %v", t))
+	}
+	body := t.In(1).Elem()
+
+	return func(r *Request) error {
+		req := reflect.New(body)
+		if err := r.Decode(req.Interface()); err != nil {
+			return err
+		}
+
+		out := v.Call([]reflect.Value{reflect.ValueOf(r), req})
+		err, _ := out[0].Interface().(error)
+		return err
+	}
+}
+
+// field reads one string field of a JSON object body, for Split.
+func field(name string) func(body []byte) (string, error) {
+	return func(body []byte) (string, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return "", err
+		}
+
+		// matched without regard to case, like json.Unmarshal does
+		var value string
+		for key, raw := range fields {
+			if strings.EqualFold(key, name) {
+				if err := json.Unmarshal(raw, &value); err != nil {
+					return "", err
+				}
+				break
+			}
+		}
+		return value, nil
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session is the connection a Client talks over: a websocket, or one of
+// the HTTP fallbacks for networks that block websockets, see fallback.go.
+// The readPump is its only reader and the writePump its only writer.
+type Session interface {
+	// Read blocks for the next message from the peer, binary says if it
+	// was sent as binary. It fails once the peer is gone.
+	Read() (msg []byte, binary bool, err error)
+
+	// Write sends one message to the peer.
+	Write(msg []byte, binary bool) error
+
+	// Ping checks the peer is still there, it fails if it is not.
+	Ping() error
+
+	// CloseWith tells the peer why the session ends, unless code is 0,
+	// and closes it.
+	CloseWith(code int, reason string) error
+
+	// Close drops the session. It may be called more than once, from
+	// any goroutine.
+	Close() error
+}
+
+// wsSession is a Session over a websocket.
+type wsSession struct {
+	conn *websocket.Conn
+	cfg  *Config
+
+	// messages read so far
+	reads int
+}
+
+// newWsSession wraps the websocket. The first message read is the HELO,
+// it must come within the HELO timeout.
+func newWsSession(conn *websocket.Conn, cfg *Config) *wsSession {
+	conn.SetReadLimit(cfg.HelloMaxSize)
+	conn.SetReadDeadline(time.Now().Add(cfg.HelloTimeout))
+	return &wsSession{conn: conn, cfg: cfg}
+}
+
+func (s *wsSession) Read() ([]byte, bool, error) {
+	// past the HELO the peer must answer pings, and keep its messages short
+	if s.reads == 1 {
+		pongWait := s.cfg.PongWait
+		s.conn.SetReadLimit(s.cfg.MaxMessageSize)
+		s.conn.SetReadDeadline(time.Now().Add(pongWait))
+		s.conn.SetPongHandler(func(string) error { s.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	}
+	s.reads++
+
+	msgType, msg, err := s.conn.ReadMessage()
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			log.Printf("error: %v", err)
+		}
+		return nil, false, err
+	}
+	return msg, msgType == websocket.BinaryMessage, nil
+}
+
+func (s *wsSession) Write(msg []byte, binary bool) error {
+	msgType := websocket.TextMessage
+	if binary {
+		msgType = websocket.BinaryMessage
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteWait))
+	return s.conn.WriteMessage(msgType, msg)
+}
+
+func (s *wsSession) Ping() error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteWait))
+	return s.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (s *wsSession) CloseWith(code int, reason string) error {
+	msg := []byte{}
+	if code != 0 {
+		msg = websocket.FormatCloseMessage(code, reason)
+	}
+
+	err := s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(s.cfg.WriteWait))
+	s.conn.Close()
+	return err
+}
+
+func (s *wsSession) Close() error {
+	return s.conn.Close()
+}
@@ -1,10 +1,12 @@
 from uuid import uuid4
+import os
 import json
 from time import sleep
 import asyncio
 import websockets
 
-key = "293faecad3499bdd836090ffc2a72693954be4c842c5728ae9a2148cf802a9359fe27a6ad42af696d3d3008557c8953f93c3681764edad05aa237932e1cc9d45678e7386f625c8d119595e67ff404312ddcfa642f4a2816fc838dc2ec3924fa044c92a7e0cb2e493519ec18a6d4879a9e091312c58f2bc472aa52dcca955799b"
+# the static auth key the server was started with
+key = os.environ["GOGO_AUTH_KEY"]
 
 ### message crafting functions
 
@@ -57,7 +59,7 @@ def craftQuestionSelect(gameId, category, pointValue):
 
     header = "GAMEPLAY"
     bodyDict = {
-        "req" : "QUESTION_SELECT",
+        "request" : "QUESTION_SELECT",
         "gameId" : gameId,
         "category" : category,
         "pointValue" : pointValue
@@ -65,14 +67,13 @@ def craftQuestionSelect(gameId, category, pointValue):
     
     return craftMessage(header, bodyDict)
 
-def craftBuzz(gameId, delay, expired):
+def craftBuzz(gameId, delay):
 
     header = "GAMEPLAY"
     bodyDict = {
-        "req" : "BUZZ",
+        "request" : "BUZZ",
         "gameId" : gameId,
-        "delay" : delay,
-        "expired" : expired
+        "delay" : delay
     }
     
     return craftMessage(header, bodyDict)
@@ -81,20 +82,20 @@ def craftAnswer(gameId, answerIndex):
 
     header = "GAMEPLAY"
     bodyDict = {
-        "req" : "ANSWER",
+        "request" : "ANSWER",
         "gameId" : gameId,
-        "answerIndex" : answerIndex
+        "index" : answerIndex
     }
     
     return craftMessage(header, bodyDict)
 
-def craftWheelSpin(gameId, spinValue):
+def craftWheelSpin(gameId, spinFactor):
     
     header = "GAMEPLAY"
     bodyDict = {
-        "req" : "WHEEL_SPIN",
+        "request" : "WHEEL_SPIN",
         "gameId" : gameId,
-        "spinValue" : spinValue
+        "spinFactor" : spinFactor
     }
     
     return craftMessage(header, bodyDict)
@@ -119,8 +120,8 @@ def handleLeave():
 
 def handleSpinWheel():
     gameId = input("gameId = ")
-    spinValue = float(input("spinValue = "))
-    return craftWheelSpin(gameId, spinValue)
+    spinFactor = int(input("spinFactor = "), 10)
+    return craftWheelSpin(gameId, spinFactor)
 
 def handleQuestionSelect():
     gameId = input("gameId = ")
@@ -130,13 +131,13 @@ def handleQuestionSelect():
 
 def handleBuzz():
     gameId = input("gameId = ")
+    # 65536 means time ran out
     delay = int(input("delay = "), 10)
-    expired = input("expired = ")
-    return craftBuzz(gameId, delay, expired)
+    return craftBuzz(gameId, delay)
 
 def handleAnswer():
     gameId = input("gameId = ")
-    answerIndex = input("answerIndex = ")
+    answerIndex = int(input("answerIndex = "), 10)
     return craftAnswer(gameId, answerIndex)
 
 commandMap = {
@@ -154,7 +155,7 @@ async def clientMain():
     clientId = str(uuid4())
     initMessage = craftInit(clientId)
 
-    websocket = await websockets.connect("ws://localhost:8080") 
+    websocket = await websockets.connect("ws://localhost:8080/ws") 
     
     await websocket.send(initMessage)
     